@@ -0,0 +1,219 @@
+package consistent_hash
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// 哈希空间上的一段左开右闭区间 (Start, End]. 跨越环尾的弧会拆分为 (Start, MaxInt64] 与 (MinInt64, End] 两段
+type ScoreRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// 覆盖整个哈希空间的区间
+var fullScoreRange = ScoreRange{Start: math.MinInt64, End: math.MaxInt64}
+
+// 将环上的弧 (start, end] 拆分为不跨越环尾的区间. start >= end 时代表弧跨越了环尾
+func splitArc(start, end int64) []ScoreRange {
+	if start < end {
+		return []ScoreRange{{Start: start, End: end}}
+	}
+	return []ScoreRange{{Start: start, End: math.MaxInt64}, {Start: math.MinInt64, End: end}}
+}
+
+// 合并相互重叠或相邻的区间
+func mergeScoreRanges(ranges []ScoreRange) []ScoreRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := []ScoreRange{ranges[0]}
+	for _, _range := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if _range.Start > last.End {
+			merged = append(merged, _range)
+			continue
+		}
+		if _range.End > last.End {
+			last.End = _range.End
+		}
+	}
+	return merged
+}
+
+// 数据 key 的副本只取决于其 score 在环上的位置时，放置策略可以按照 score 区间描述数据的归属
+type arcPlacement interface {
+	// 变更前后副本列表可能发生变化的数据 key 所在的 score 区间，replicas 为数据 key 副本数的上限
+	affectedArcs(before, after *ringSnapshot, replicas int) []ScoreRange
+	// 物理节点作为首个副本所拥有的 score 区间
+	ranges(snapshot *ringSnapshot, nodeID string) []ScoreRange
+	// 变更前后首个副本发生变化的 score 区间，按照迁出、迁入节点分组
+	movedRanges(before, after *ringSnapshot) map[migrateRoute][]ScoreRange
+	// score 位于区间内的数据 key 可能存放副本的物理节点，replicas 为数据 key 副本数的上限
+	arcOwners(snapshot *ringSnapshot, arcs []ScoreRange, replicas int) map[string]struct{}
+}
+
+// 只在一侧快照中存在的虚拟节点会改变经过它的数据 key 的副本列表，沿逆时针方向推算出这些数据 key 的范围
+func (r *ringPlacement) affectedArcs(before, after *ringSnapshot, replicas int) []ScoreRange {
+	var arcs []ScoreRange
+	for _, pair := range [][2]*ringSnapshot{{before, after}, {after, before}} {
+		from, to := pair[0], pair[1]
+		for i, score := range from.scores {
+			for _, nodeID := range from.nodes[i] {
+				if !to.hasVirtualNode(score, nodeID) {
+					arcs = append(arcs, from.arcBefore(i, nodeID, replicas)...)
+				}
+			}
+		}
+	}
+	return mergeScoreRanges(arcs)
+}
+
+// 快照中是否存在位于 score 且属于 nodeID 的虚拟节点
+func (r *ringSnapshot) hasVirtualNode(score int64, nodeID string) bool {
+	index := sort.Search(len(r.scores), func(i int) bool {
+		return r.scores[i] >= score
+	})
+	return index < len(r.scores) && r.scores[index] == score && contains(r.nodes[index], nodeID)
+}
+
+// 区间内的数据 key 从区间内的某个虚拟节点或者区间之后的首个虚拟节点开始行走，
+// 途经的物理节点只可能是区间内的虚拟节点，或者区间之后的前 replicas 个不同的物理节点
+func (r *ringPlacement) arcOwners(snapshot *ringSnapshot, arcs []ScoreRange, replicas int) map[string]struct{} {
+	owners := make(map[string]struct{})
+	if len(snapshot.scores) == 0 {
+		return owners
+	}
+
+	for _, arc := range arcs {
+		i := sort.Search(len(snapshot.scores), func(i int) bool {
+			return snapshot.scores[i] > arc.Start
+		})
+		for ; i < len(snapshot.scores) && snapshot.scores[i] <= arc.End; i++ {
+			for _, nodeID := range snapshot.nodes[i] {
+				owners[nodeID] = struct{}{}
+			}
+		}
+		for _, nodeID := range snapshot.walkFrom(i%len(snapshot.scores), replicas) {
+			owners[nodeID] = struct{}{}
+		}
+	}
+	return owners
+}
+
+// 从下标为 i 的虚拟节点沿逆时针方向行走，直到途经 replicas 个 nodeID 之外的不同物理节点，返回途经的弧.
+// score 位于弧之外的数据 key 在到达下标为 i 的虚拟节点之前已经凑齐了副本，不受该虚拟节点的影响
+func (r *ringSnapshot) arcBefore(i int, nodeID string, replicas int) []ScoreRange {
+	ranged := make(map[string]struct{}, replicas)
+	for step := 1; step < len(r.scores); step++ {
+		j := (i - step + len(r.scores)) % len(r.scores)
+		for _, _nodeID := range r.nodes[j] {
+			if _nodeID != nodeID {
+				ranged[_nodeID] = struct{}{}
+			}
+		}
+		if len(ranged) >= replicas {
+			return splitArc(r.scores[j], r.scores[i])
+		}
+	}
+	return []ScoreRange{fullScoreRange}
+}
+
+// 推算出受节点变更影响的 score 区间，以及可能存放这些数据 key 副本的物理节点. 放置策略不支持推算区间、
+// 有界负载模式，或者数据 key 的副本数上限未知时返回 false，需要扫描全部节点下的全部数据 key
+func (c *ConsistentHash) affectedArcs(ctx context.Context, before, after *ringSnapshot) ([]ScoreRange, map[string]struct{}, bool, error) {
+	// 有界负载模式下数据的去向取决于各节点的负载
+	if c.opts.boundedLoads {
+		return nil, nil, false, nil
+	}
+	arcPlacement, ok := c.placement.(arcPlacement)
+	if !ok {
+		return nil, nil, false, nil
+	}
+
+	replicas, err := c.hashRing.DataKeyReplicas(ctx)
+	if err != nil || replicas <= 0 {
+		return nil, nil, false, err
+	}
+
+	arcs := arcPlacement.affectedArcs(before, after, replicas)
+	owners := arcPlacement.arcOwners(before, arcs, replicas)
+	for nodeID := range arcPlacement.arcOwners(after, arcs, replicas) {
+		owners[nodeID] = struct{}{}
+	}
+	return arcs, owners, true, nil
+}
+
+// score 是否位于任一区间内
+func inRanges(ranges []ScoreRange, score int64) bool {
+	for _, _range := range ranges {
+		if score > _range.Start && score <= _range.End {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取节点下 score 位于任一区间内的数据 key
+func rangeDataKeys(ctx context.Context, scoredIndex ScoredDataKeyIndex, nodeID string, ranges []ScoreRange) (map[string]struct{}, error) {
+	dataKeys := make(map[string]struct{})
+	for _, _range := range ranges {
+		_dataKeys, err := scoredIndex.RangeDataKeys(ctx, nodeID, _range.Start, _range.End)
+		if err != nil {
+			return nil, err
+		}
+		for dataKey := range _dataKeys {
+			dataKeys[dataKey] = struct{}{}
+		}
+	}
+	return dataKeys, nil
+}
+
+// 分页遍历数据 key 时每一页的大小
+const scanDataKeysCount = 1000
+
+// 数据 key 索引不支持按照 score 读取时，分页遍历节点下的数据 key，只保留 score 位于任一区间内的数据 key
+func (c *ConsistentHash) scanDataKeys(ctx context.Context, nodeID string, ranges []ScoreRange) (map[string]struct{}, error) {
+	dataKeys := make(map[string]struct{})
+	var cursor string
+	for {
+		page, next, err := c.dataKeyIndex.ScanDataKeys(ctx, nodeID, cursor, scanDataKeysCount)
+		if err != nil {
+			return nil, err
+		}
+		for _, dataKey := range page {
+			if inRanges(ranges, c.hash(dataKey)) {
+				dataKeys[dataKey] = struct{}{}
+			}
+		}
+		if next == "" {
+			return dataKeys, nil
+		}
+		cursor = next
+	}
+}
+
+// 记录数据 key 之前调用，保证哈希环中记录的副本数上限不低于 replicas. 上限只增不减，
+// 因此每个进程只需要在遇到更大的副本数时更新一次，更新是原子的，无需获取哈希环的锁
+func (c *ConsistentHash) ensureDataKeyReplicas(ctx context.Context, replicas int) error {
+	if int64(replicas) <= atomic.LoadInt64(&c.dataKeyReplicas) {
+		return nil
+	}
+
+	if err := c.hashRing.RaiseDataKeyReplicas(ctx, replicas); err != nil {
+		return err
+	}
+
+	for {
+		known := atomic.LoadInt64(&c.dataKeyReplicas)
+		if int64(replicas) <= known || atomic.CompareAndSwapInt64(&c.dataKeyReplicas, known, int64(replicas)) {
+			return nil
+		}
+	}
+}
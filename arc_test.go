@@ -0,0 +1,209 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+// 隐藏按照 score 扫描的能力，迫使迁移分页遍历节点下的数据 key
+type unscoredIndex struct {
+	DataKeyIndex
+}
+
+// 统计分页遍历过的节点
+type scanCountingIndex struct {
+	unscoredIndex
+	fullScans int
+	scanned   map[string]struct{}
+}
+
+func (s *scanCountingIndex) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	s.fullScans++
+	return s.unscoredIndex.DataKeys(ctx, nodeID)
+}
+
+func (s *scanCountingIndex) ScanDataKeys(ctx context.Context, nodeID, cursor string, count int) ([]string, string, error) {
+	s.scanned[nodeID] = struct{}{}
+	return s.unscoredIndex.ScanDataKeys(ctx, nodeID, cursor, count)
+}
+
+// 统计全量读取节点数据 key 的次数
+type countingIndex struct {
+	*local.DataKeyIndex
+	fullScans int
+}
+
+func (c *countingIndex) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	c.fullScans++
+	return c.DataKeyIndex.DataKeys(ctx, nodeID)
+}
+
+func Test_arc_migration(t *testing.T) {
+	ctx := context.Background()
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+
+	scored := &countingIndex{DataKeyIndex: local.NewDataKeyIndex()}
+	arcHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), migrator, WithReplicas(8), WithDataKeyIndex(scored))
+	scanHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), migrator, WithReplicas(8),
+		WithDataKeyIndex(unscoredIndex{DataKeyIndex: local.NewDataKeyIndex()}))
+
+	type step func(consistentHash *ConsistentHash) (*MigrationReport, error)
+	addNode := func(nodeID string, weight float64) step {
+		return func(consistentHash *ConsistentHash) (*MigrationReport, error) {
+			return consistentHash.AddNode(ctx, nodeID, weight)
+		}
+	}
+
+	for _, consistentHash := range []*ConsistentHash{arcHash, scanHash} {
+		for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+			if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 300; i++ {
+			if _, err := consistentHash.GetNodes(ctx, fmt.Sprintf("data_%d", i), 1+i%3); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	steps := []step{
+		addNode("node_d", 1),
+		addNode("node_e", 2),
+		func(consistentHash *ConsistentHash) (*MigrationReport, error) {
+			return consistentHash.UpdateNodeWeight(ctx, "node_a", 3)
+		},
+		func(consistentHash *ConsistentHash) (*MigrationReport, error) {
+			return consistentHash.RemoveNode(ctx, "node_b")
+		},
+	}
+
+	scored.fullScans = 0
+	for i, _step := range steps {
+		arcReport, err := _step(arcHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanReport, err := _step(scanHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if arcReport.KeyCount() == 0 {
+			t.Fatalf("step: %d, expect migrations", i)
+		}
+		if !reflect.DeepEqual(migrationRoutes(arcReport), migrationRoutes(scanReport)) {
+			t.Fatalf("step: %d, range scan: %v, page scan: %v", i, migrationRoutes(arcReport), migrationRoutes(scanReport))
+		}
+	}
+	if scored.fullScans != 0 {
+		t.Fatalf("expect no full scans, got: %d", scored.fullScans)
+	}
+}
+
+func Test_arc_migration_owners(t *testing.T) {
+	ctx := context.Background()
+	index := &scanCountingIndex{unscoredIndex: unscoredIndex{DataKeyIndex: local.NewDataKeyIndex()}}
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithReplicas(1), WithDataKeyIndex(index))
+
+	var nodeIDs []string
+	for i := 0; i < 10; i++ {
+		nodeID := fmt.Sprintf("node_%d", i)
+		nodeIDs = append(nodeIDs, nodeID)
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 300; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index.fullScans, index.scanned = 0, make(map[string]struct{})
+	if _, err := consistentHash.AddNode(ctx, "node_new", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 每个节点只有一个虚拟节点、数据 key 只有一个副本，只有新节点之后的节点可能迁出数据
+	if index.fullScans != 0 || len(index.scanned) > 2 {
+		t.Fatalf("expect at most 2 scanned nodes without full scans, got full scans: %d, scanned: %v", index.fullScans, index.scanned)
+	}
+
+	// 迁移之后，每个数据 key 依然记录在它所属的节点下
+	for _, nodeID := range append(nodeIDs, "node_new") {
+		dataKeys, err := index.DataKeyIndex.DataKeys(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for dataKey := range dataKeys {
+			if located, _ := consistentHash.Locate(ctx, dataKey); located != nodeID {
+				t.Fatalf("data key: %s, indexed: %s, located: %s", dataKey, nodeID, located)
+			}
+		}
+	}
+}
+
+func Test_raise_data_key_replicas(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	})
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 其他进程持有哈希环的锁时，记录数据 key 依然可以提高副本数上限
+	if err := hashRing.Lock(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = hashRing.Unlock(ctx)
+	}()
+
+	for _, n := range []int{2, 3, 1} {
+		done := make(chan error, 1)
+		go func() {
+			_, err := consistentHash.GetNodes(ctx, "data_a", n)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("get nodes blocked")
+		}
+	}
+
+	if replicas, _ := hashRing.DataKeyReplicas(ctx); replicas != 3 {
+		t.Fatalf("expect data key replicas: 3, got: %d", replicas)
+	}
+}
+
+func migrationRoutes(report *MigrationReport) map[string]map[string]struct{} {
+	routes := make(map[string]map[string]struct{}, len(report.Tasks))
+	for _, task := range report.Tasks {
+		routes[task.From+"->"+task.To] = task.DataKeys
+	}
+	return routes
+}
+
+func Test_split_arc(t *testing.T) {
+	ranges := mergeScoreRanges(append(splitArc(90, 10), ScoreRange{Start: 5, End: 20}, ScoreRange{Start: 40, End: 50}))
+	expect := []ScoreRange{{Start: fullScoreRange.Start, End: 20}, {Start: 40, End: 50}, {Start: 90, End: fullScoreRange.End}}
+	if !reflect.DeepEqual(ranges, expect) {
+		t.Fatalf("expect: %v, got: %v", expect, ranges)
+	}
+}
@@ -0,0 +1,224 @@
+package consistent_hash
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 有界负载模式下，每个节点可以承载的数据 key 个数上限：ceil((1+ε) * 平均负载)
+func (c *ConsistentHash) boundedCapacity(total, nodeCount int) int {
+	if nodeCount == 0 {
+		return 0
+	}
+	return int(math.Ceil((1 + c.opts.boundedLoadsEpsilon) * float64(total) / float64(nodeCount)))
+}
+
+// 有界负载模式下为数据 key 选择 n 个副本节点. 已经持有该数据的节点优先保留，保证同一个数据 key
+// 不会因为负载的波动被记录到多个节点下；其余的副本沿顺时针方向选择负载未达到上限的节点.
+// 同时返回原本就持有该数据 key 的节点
+func (c *ConsistentHash) boundedPlace(ctx context.Context, snapshot *ringSnapshot, dataKey string, dataScore int64, n int) ([]string, []string, error) {
+	// 没有记录数据 key 时无从得知节点的负载，退化为普通的放置策略
+	if c.dataKeyIndex == nil {
+		return snapshot.locate(dataKey, dataScore, n), nil, nil
+	}
+
+	if n > snapshot.nodeCount {
+		n = snapshot.nodeCount
+	}
+
+	loads, err := c.nodeLoads(ctx, snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	walk := snapshot.locate(dataKey, dataScore, snapshot.nodeCount)
+	var (
+		total  int
+		chosen []string
+	)
+	for _, nodeID := range walk {
+		total += loads[nodeID]
+		if len(chosen) == n {
+			continue
+		}
+
+		holding, err := c.dataKeyIndex.HasDataKey(ctx, nodeID, dataKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if holding {
+			chosen = append(chosen, nodeID)
+		}
+	}
+	holders := append([]string(nil), chosen...)
+
+	if len(chosen) == n {
+		return chosen, holders, nil
+	}
+
+	// 计算上限时需要把当前这个新的数据 key 计算在内
+	capacity := c.boundedCapacity(total+1, len(walk))
+	for _, nodeID := range walk {
+		if len(chosen) == n {
+			break
+		}
+		if contains(chosen, nodeID) || loads[nodeID] >= capacity {
+			continue
+		}
+		chosen = append(chosen, nodeID)
+	}
+
+	// 所有节点的负载都达到上限时，退化为普通的一致性哈希
+	for _, nodeID := range walk {
+		if len(chosen) == n {
+			break
+		}
+		if !contains(chosen, nodeID) {
+			chosen = append(chosen, nodeID)
+		}
+	}
+
+	// 副本按照顺时针方向的先后顺序返回
+	sort.SliceStable(chosen, func(i, j int) bool {
+		return indexOf(walk, chosen[i]) < indexOf(walk, chosen[j])
+	})
+	return chosen, holders, nil
+}
+
+// 有界负载模式下各节点负载的本地缓存. 哈希环的版本号发生变化或者超过有效期时重新读取，
+// 本进程记录数据 key 时同步累加，避免每次定位都读取全部节点的负载
+type loadCache struct {
+	mutex    sync.Mutex
+	version  int64
+	expireAt time.Time
+	loads    map[string]int
+}
+
+// 负载缓存的有效期，用于感知其他进程记录或者淘汰的数据 key
+const loadCacheTTL = time.Second
+
+// 返回快照中各节点的负载，结果归调用方所有
+func (c *ConsistentHash) nodeLoads(ctx context.Context, snapshot *ringSnapshot) (map[string]int, error) {
+	c.loadCache.mutex.Lock()
+	if c.loadCache.loads != nil && c.loadCache.version == snapshot.version && time.Now().Before(c.loadCache.expireAt) {
+		loads := make(map[string]int, len(c.loadCache.loads))
+		for nodeID, load := range c.loadCache.loads {
+			loads[nodeID] = load
+		}
+		c.loadCache.mutex.Unlock()
+		return loads, nil
+	}
+	c.loadCache.mutex.Unlock()
+
+	loads := make(map[string]int, len(snapshot.members))
+	for _, nodeID := range snapshot.members {
+		load, err := c.dataKeyIndex.DataKeysCount(ctx, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		loads[nodeID] = load
+	}
+
+	cached := make(map[string]int, len(loads))
+	for nodeID, load := range loads {
+		cached[nodeID] = load
+	}
+	c.loadCache.mutex.Lock()
+	c.loadCache.version, c.loadCache.expireAt, c.loadCache.loads = snapshot.version, time.Now().Add(loadCacheTTL), cached
+	c.loadCache.mutex.Unlock()
+	return loads, nil
+}
+
+// 数据 key 被记录到新的节点下，同步累加缓存中的负载. 缓存对应的版本号已经过期时无需处理
+func (c *ConsistentHash) addNodeLoads(version int64, nodeIDs []string) {
+	c.loadCache.mutex.Lock()
+	defer c.loadCache.mutex.Unlock()
+	if c.loadCache.loads == nil || c.loadCache.version != version {
+		return
+	}
+	for _, nodeID := range nodeIDs {
+		c.loadCache.loads[nodeID]++
+	}
+}
+
+// 节点变更时，在有界负载模式下重新分配数据 key，同时维护各个节点的负载
+type boundedBalancer struct {
+	after    *ringSnapshot
+	loads    map[string]int
+	capacity int
+}
+
+func (c *ConsistentHash) newBoundedBalancer(after *ringSnapshot, holders map[string][]string) *boundedBalancer {
+	loads := make(map[string]int)
+	var total int
+	for _, nodeIDs := range holders {
+		for _, nodeID := range nodeIDs {
+			loads[nodeID]++
+			total++
+		}
+	}
+
+	return &boundedBalancer{
+		after:    after,
+		loads:    loads,
+		capacity: c.boundedCapacity(total, after.nodeCount),
+	}
+}
+
+// 处理数据 key 的顺序. 有界负载模式下分配结果依赖处理顺序，因此需要排序保证结果确定
+func (b *boundedBalancer) order(holders map[string][]string) []string {
+	dataKeys := make([]string, 0, len(holders))
+	for dataKey := range holders {
+		dataKeys = append(dataKeys, dataKey)
+	}
+	if b != nil {
+		sort.Strings(dataKeys)
+	}
+	return dataKeys
+}
+
+// 沿顺时针方向选择副本：负载未超过上限的持有者保留数据，位于其之前且负载未达到上限的节点会取而代之，
+// 这与普通一致性哈希中新节点接管前驱区间数据的语义一致. 负载超过上限的持有者会让出数据
+func (b *boundedBalancer) place(dataKey string, dataScore int64, holders []string) []string {
+	n := len(holders)
+	if n > b.after.nodeCount {
+		n = b.after.nodeCount
+	}
+
+	walk := b.after.locate(dataKey, dataScore, b.after.nodeCount)
+	chosen := make([]string, 0, n)
+	for _, nodeID := range walk {
+		if len(chosen) == n {
+			break
+		}
+		// 持有者的负载中已经包含了当前数据 key
+		if contains(holders, nodeID) && b.loads[nodeID] <= b.capacity || b.loads[nodeID] < b.capacity {
+			chosen = append(chosen, nodeID)
+		}
+	}
+
+	for _, nodeID := range walk {
+		if len(chosen) == n {
+			break
+		}
+		if !contains(chosen, nodeID) {
+			chosen = append(chosen, nodeID)
+		}
+	}
+
+	// 同步更新负载，影响后续数据 key 的分配
+	for _, nodeID := range holders {
+		if !contains(chosen, nodeID) {
+			b.loads[nodeID]--
+		}
+	}
+	for _, nodeID := range chosen {
+		if !contains(holders, nodeID) {
+			b.loads[nodeID]++
+		}
+	}
+	return chosen
+}
@@ -0,0 +1,108 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_bounded_loads(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithReplicas(1), WithBoundedLoads(0.25))
+
+	check := func(dataKeys int, nodeIDs ...string) {
+		capacity := consistentHash.boundedCapacity(dataKeys, len(nodeIDs))
+		var total int
+		for _, nodeID := range nodeIDs {
+			count, _ := hashRing.DataKeysCount(ctx, nodeID)
+			if count > capacity {
+				t.Fatalf("node: %s load: %d exceeds capacity: %d", nodeID, count, capacity)
+			}
+			total += count
+		}
+		if total != dataKeys {
+			t.Fatalf("expect %d data keys, got %d", dataKeys, total)
+		}
+	}
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 300; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 重复查询时，数据 key 保持在原节点上
+		again, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node != again {
+			t.Fatalf("data: %s moved from %s to %s", dataKey, node, again)
+		}
+	}
+	check(300, "node_a", "node_b", "node_c")
+
+	if _, err := consistentHash.AddNode(ctx, "node_d", 1); err != nil {
+		t.Fatal(err)
+	}
+	check(300, "node_a", "node_b", "node_c", "node_d")
+
+	if _, err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	check(300, "node_b", "node_c", "node_d")
+}
+
+// 统计读取节点负载的次数
+type loadCountingIndex struct {
+	*local.DataKeyIndex
+	counts int
+}
+
+func (l *loadCountingIndex) DataKeysCount(ctx context.Context, nodeID string) (int, error) {
+	l.counts++
+	return l.DataKeyIndex.DataKeysCount(ctx, nodeID)
+}
+
+func Test_bounded_loads_cache(t *testing.T) {
+	ctx := context.Background()
+	index := &loadCountingIndex{DataKeyIndex: local.NewDataKeyIndex()}
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithReplicas(1), WithBoundedLoads(0.25), WithDataKeyIndex(index))
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index.counts = 0
+	for i := 0; i < 300; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 负载只在缓存失效时读取，之后由本进程的记录同步累加，而不是每次定位都读取全部节点
+	if index.counts > 30 {
+		t.Fatalf("expect cached loads, got %d load reads", index.counts)
+	}
+
+	capacity := consistentHash.boundedCapacity(300, 3)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if count, _ := index.DataKeyIndex.DataKeysCount(ctx, nodeID); count > capacity {
+			t.Fatalf("node: %s load: %d exceeds capacity: %d", nodeID, count, capacity)
+		}
+	}
+}
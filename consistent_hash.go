@@ -4,18 +4,38 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// 节点的权重非法，或者换算后的虚拟节点个数超出限制时，AddNode/UpdateNodeWeight 返回的错误会包装该错误
+var ErrInvalidWeight = errors.New("invalid weight")
+
 // 通过 redis zset 实现一致性哈希
 type ConsistentHash struct {
 	hashRing  HashRing
 	migrator  Migrator
 	encryptor Encryptor
-	opts      ConsistentHashOptions
+	placement placement
+	// 数据 key 的索引，不记录数据 key 时为 nil
+	dataKeyIndex DataKeyIndex
+	opts         ConsistentHashOptions
+	// 哈希环的只读快照 *ringSnapshot，由 AddNode/RemoveNode 原子发布
+	snapshot     atomic.Value
+	refreshMutex sync.Mutex
+	// 当前进程内正在执行的异步迁移任务，jobID -> *migrationJob
+	jobs sync.Map
+	// 已经确认记录在哈希环中的数据 key 副本数上限
+	dataKeyReplicas int64
+	// 有界负载模式下各节点负载的本地缓存
+	loadCache loadCache
+	// 选项之间相互冲突时的错误，由首次变更哈希环的操作返回
+	optsErr error
 }
 
+// 选项之间相互冲突时，变更哈希环的操作都会返回错误. 需要在构造时发现配置错误，请使用 NewConsistentHashE
 func NewConsistentHash(hashRing HashRing, encryptor Encryptor, migrator Migrator, opts ...ConsistentHashOption) *ConsistentHash {
 	ch := ConsistentHash{
 		hashRing:  hashRing,
@@ -28,91 +48,136 @@ func NewConsistentHash(hashRing HashRing, encryptor Encryptor, migrator Migrator
 	}
 
 	repair(&ch.opts)
+	ch.placement = ch.opts.newPlacement(&ch)
+
+	// 基于区间的迁移函数要求放置策略能够按照 score 区间描述数据归属，属于配置错误，拒绝后续的变更
+	ch.optsErr = ch.checkRangeMigrator()
+
+	// 未单独指定数据 key 的索引时，使用哈希环自身实现的索引
+	ch.dataKeyIndex = ch.opts.dataKeyIndex
+	if dataKeyIndex, ok := hashRing.(DataKeyIndex); ok && ch.dataKeyIndex == nil {
+		ch.dataKeyIndex = dataKeyIndex
+	}
+	if ch.dataKeyIndex == nil || ch.opts.dataKeyTracking == DataKeyTrackingNone {
+		ch.dataKeyIndex = nil
+		ch.opts.dataKeyTracking = DataKeyTrackingNone
+	}
 	return &ch
 }
 
-// 添加节点需要触发数据迁移
-func (c *ConsistentHash) AddNode(ctx context.Context, nodeID string, weight int) error {
+// 与 NewConsistentHash 一致，但选项之间相互冲突时直接返回错误
+func NewConsistentHashE(hashRing HashRing, encryptor Encryptor, migrator Migrator, opts ...ConsistentHashOption) (*ConsistentHash, error) {
+	ch := NewConsistentHash(hashRing, encryptor, migrator, opts...)
+	if ch.optsErr != nil {
+		return nil, ch.optsErr
+	}
+	return ch, nil
+}
+
+// 添加节点需要触发数据迁移. 哈希环的变更是原子的，中途失败时会回滚到变更前的状态
+func (c *ConsistentHash) AddNode(ctx context.Context, nodeID string, weight float64, opts ...NodeOption) (*MigrationReport, error) {
+	var nodeOpts NodeOptions
+	for _, opt := range opts {
+		opt(&nodeOpts)
+	}
+
 	// 1 加全局分布式锁
 	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
-		return err
+		return nil, err
 	}
 
 	defer func() {
 		_ = c.hashRing.Unlock(ctx)
 	}()
 
+	return c.addNode(ctx, nodeID, weight, nodeOpts)
+}
+
+// 在持有哈希环锁的前提下添加节点
+func (c *ConsistentHash) addNode(ctx context.Context, nodeID string, weight float64, nodeOpts NodeOptions) (*MigrationReport, error) {
 	// 2 如果节点已经存在了，直接返回重复创建的错误
 	nodes, err := c.hashRing.Nodes(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for node := range nodes {
 		if node == nodeID {
-			return errors.New("repeat node")
+			return nil, errors.New("repeat node")
 		}
 	}
 
-	// 3 根据 replicas 配置，计算出使用的虚拟节点个数
-	replicas := c.getValidWeight(weight) * c.opts.replicas
-	// 4. 将计算得到的 replicas 个数与 nodeID 的映射关系放到 hash ring 中，同时也能标识出当前 nodeID 已经存在
-	if err = c.hashRing.AddNodeToReplica(ctx, nodeID, replicas); err != nil {
-		return err
+	// 3 根据权重与 replicas 配置，计算出使用的虚拟节点个数
+	replicas, err := c.getReplicas(nodes, nodeID, weight)
+	if err != nil {
+		return nil, err
 	}
 
-	var migrateTasks []func()
-	for i := 0; i < replicas; i++ {
-		// 5 使用 encryptor，推算出对应的 k 个虚拟节点的数值
-		nodeKey := c.getRawNodeKey(nodeID, i)
-		virtualScore := c.encryptor.Encrypt(nodeKey)
-
-		// 6 批量执行，将对应的虚拟节点添加到 hash ring 当中
-		if err := c.hashRing.Add(ctx, virtualScore, nodeKey); err != nil {
+	version, migrateTasks, err := c.commit(ctx, func(tx *ringTx, before *ringSnapshot) error {
+		// 4. 将计算得到的 replicas 个数与 nodeID 的映射关系放到 hash ring 中，同时也能标识出当前 nodeID 已经存在
+		if err := tx.AddNodeToReplica(ctx, nodeID, replicas); err != nil {
 			return err
 		}
 
-		// 7 调用 migrateIn 方法，获取到当前这个 virtualScore 的添加操作，会导致有哪些数据需要从哪个节点迁移到哪个节点
-		// from: 数据迁移起点的节点 id
-		// to: 数据迁移终点的节点 id
-		// data: 需要迁移的数据的 key
-		from, to, datas, err := c.migrateIn(ctx, virtualScore, nodeID)
+		// 节点的元数据与虚拟节点在同一次变更中写入
+		if nodeOpts.meta != nil {
+			if err := c.setNodeMeta(ctx, tx, nodeID, *nodeOpts.meta); err != nil {
+				return err
+			}
+		}
+
+		// 5 由放置策略推算出需要写入的虚拟节点
+		virtualNodes, err := c.placement.join(before, nodeID, replicas)
 		if err != nil {
 			return err
 		}
 
-		// 无数据需要迁移，则直接跳过
-		if len(datas) == 0 {
-			continue
+		for _, virtualNode := range virtualNodes {
+			// 6 批量执行，将对应的虚拟节点添加到 hash ring 当中
+			if err := tx.Add(ctx, virtualNode); err != nil {
+				return err
+			}
 		}
-
-		// 创建数据迁移任务，但不是立即执行，而是放在方法返回前统一批量执行
-		migrateTasks = append(migrateTasks, func() {
-			_ = c.migrator(ctx, datas, from, to)
-		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	c.batchExecuteMigrator(migrateTasks)
-
-	return nil
+	c.publishEvent(ctx, &RingEvent{
+		Type:     RingEventNodeAdded,
+		NodeID:   nodeID,
+		Version:  version,
+		Replicas: replicas,
+		State:    NodeStateActive,
+	})
+
+	// 7 在方法返回前统一批量执行数据迁移任务，迁移失败的任务会体现在迁移报告与返回的错误中.
+	// 异步迁移模式下，则创建迁移任务后立即返回
+	return c.executeMigration(ctx, version, migrateTasks)
 }
 
 // 删除节点需要触发数据迁移，
 // 作为使用方，需要知道，有哪些数据需要完成迁移，从哪里迁移到哪里
-func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) error {
+func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) (*MigrationReport, error) {
 	// 1 加全局分布式锁
 	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
-		return err
+		return nil, err
 	}
 
 	defer func() {
 		_ = c.hashRing.Unlock(ctx)
 	}()
 
+	return c.removeNode(ctx, nodeID)
+}
+
+// 在持有哈希环锁的前提下删除节点
+func (c *ConsistentHash) removeNode(ctx context.Context, nodeID string) (*MigrationReport, error) {
 	// 2 如果节点不存在，直接返回失败
 	nodes, err := c.hashRing.Nodes(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var (
@@ -128,122 +193,340 @@ func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) error {
 	}
 
 	if !nodeExist {
-		return errors.New("invalid node id")
-	}
-
-	if err = c.hashRing.DeleteNodeToReplica(ctx, nodeID); err != nil {
-		return err
+		return nil, errors.New("invalid node id")
 	}
 
-	var migrateTasks []func()
-	// 3 根据 replicas，计算出使用的虚拟节点个数
-	for i := 0; i < replicas; i++ {
-		// 4 使用 encryptor，推算出对应的 k 个虚拟节点数值
-		virtualScore := c.encryptor.Encrypt(fmt.Sprintf("%s_%d", nodeID, i))
-		// 5 批量执行节点删除操作，如果涉及到数据迁移操作，调用 migrator
-		from, to, datas, err := c.migrateOut(ctx, virtualScore, nodeID)
+	version, migrateTasks, err := c.commit(ctx, func(tx *ringTx, before *ringSnapshot) error {
+		// 3 根据 replicas，由放置策略推算出需要删除的虚拟节点
+		virtualNodes, err := c.placement.leave(before, nodeID, replicas)
 		if err != nil {
 			return err
 		}
 
-		nodeKey := c.getRawNodeKey(nodeID, i)
-		if err = c.hashRing.Rem(ctx, virtualScore, nodeKey); err != nil {
+		if err := tx.DeleteNodeToReplica(ctx, nodeID); err != nil {
 			return err
 		}
 
-		if len(datas) == 0 {
-			continue
+		if err := tx.DeleteNodeMeta(ctx, nodeID); err != nil {
+			return err
 		}
 
-		// 创建数据迁移任务，但不是立即执行，而是放在方法返回前统一批量执行
-		migrateTasks = append(migrateTasks, func() {
-			_ = c.migrator(ctx, datas, from, to)
-		})
+		if err := tx.DeleteNodeState(ctx, nodeID); err != nil {
+			return err
+		}
 
+		// 4 批量执行节点删除操作
+		for _, virtualNode := range virtualNodes {
+			if err := tx.Rem(ctx, virtualNode); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	c.batchExecuteMigrator(migrateTasks)
-
-	return nil
-}
-
-func (c *ConsistentHash) batchExecuteMigrator(migrateTasks []func()) {
-	// 执行所有的数据迁移任务
-	var wg sync.WaitGroup
-	for _, migrateTask := range migrateTasks {
-		// shadow
-		migrateTask := migrateTask
-		wg.Add(1)
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
+	c.publishEvent(ctx, &RingEvent{
+		Type:    RingEventNodeRemoved,
+		NodeID:  nodeID,
+		Version: version,
+	})
 
-				}
-				wg.Done()
-			}()
-			migrateTask()
-		}()
-	}
-	wg.Wait()
+	// 5 如果涉及到数据迁移操作，调用 migrator
+	return c.executeMigration(ctx, version, migrateTasks)
 }
 
-func (c *ConsistentHash) GetNode(ctx context.Context, dataKey string) (string, error) {
+// 调整节点的权重. 只增删权重变化对应的虚拟节点，并迁移这些虚拟节点获得或者失去的数据，
+// 避免先 RemoveNode 再 AddNode 导致节点的全部数据迁出再迁回
+func (c *ConsistentHash) UpdateNodeWeight(ctx context.Context, nodeID string, weight float64) (*MigrationReport, error) {
 	// 1 加全局分布式锁
 	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	defer func() {
 		_ = c.hashRing.Unlock(ctx)
 	}()
 
-	// 1 输入一个数据 key，查询其所属的节点 id
-	dataScore := c.encryptor.Encrypt(dataKey)
-	ceilingScore, err := c.hashRing.Ceiling(ctx, dataScore)
+	// 2 如果节点不存在，直接返回失败
+	nodes, err := c.hashRing.Nodes(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if ceilingScore == -1 {
-		return "", errors.New("no node available")
+	oldReplicas, ok := nodes[nodeID]
+	if !ok {
+		return nil, errors.New("invalid node id")
 	}
 
-	nodes, err := c.hashRing.Node(ctx, ceilingScore)
+	// 3 虚拟节点个数没有变化，则无需变更哈希环
+	newReplicas, err := c.getReplicas(nodes, nodeID, weight)
+	if err != nil {
+		return nil, err
+	}
+	if newReplicas == oldReplicas {
+		return &MigrationReport{}, nil
+	}
+
+	version, migrateTasks, err := c.commit(ctx, func(tx *ringTx, before *ringSnapshot) error {
+		// 4 由放置策略推算出需要增删的虚拟节点
+		adds, rems, err := c.placement.resize(before, nodeID, oldReplicas, newReplicas)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.AddNodeToReplica(ctx, nodeID, newReplicas); err != nil {
+			return err
+		}
+
+		for _, virtualNode := range rems {
+			if err = tx.Rem(ctx, virtualNode); err != nil {
+				return err
+			}
+		}
+
+		for _, virtualNode := range adds {
+			if err = tx.Add(ctx, virtualNode); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.publishEvent(ctx, &RingEvent{
+		Type:     RingEventNodeWeightChanged,
+		NodeID:   nodeID,
+		Version:  version,
+		Replicas: newReplicas,
+	})
+
+	// 5 迁移增删的虚拟节点所涉及的数据
+	return c.executeMigration(ctx, version, migrateTasks)
+}
+
+// 读路径不加哈希环的锁，而是基于不可变的快照完成查询
+func (c *ConsistentHash) GetNode(ctx context.Context, dataKey string) (string, error) {
+	nodes, err := c.GetNodes(ctx, dataKey, 1)
 	if err != nil {
 		return "", err
 	}
+	return nodes[0], nil
+}
+
+// 返回数据 key 沿哈希环顺时针方向的前 n 个不同的物理节点，作为数据的副本偏好列表.
+// 哈希环上的物理节点不足 n 个时，返回全部物理节点. 非 active 的节点会被跳过，由后续不同的 active 节点顶替.
+// 只有 DataKeyTrackingLookup 模式下才会记录数据 key，其他模式下与 LocateN 一致
+func (c *ConsistentHash) GetNodes(ctx context.Context, dataKey string, n int) ([]string, error) {
+	_, nodes, err := c.getNodes(ctx, dataKey, n)
+	return nodes, err
+}
+
+func (c *ConsistentHash) getNodes(ctx context.Context, dataKey string, n int) (*ringSnapshot, []string, error) {
+	if c.opts.dataKeyTracking == DataKeyTrackingLookup {
+		return c.trackNodes(ctx, dataKey, n)
+	}
+	return c.locateNodes(ctx, dataKey, n)
+}
+
+// 返回数据 key 的前 n 个副本节点，以及完成定位所使用的快照，同时记录数据 key 与副本节点的映射关系
+func (c *ConsistentHash) trackNodes(ctx context.Context, dataKey string, n int) (*ringSnapshot, []string, error) {
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("invalid replica count: %d", n)
+	}
+
+	// 1 读取哈希环快照，输入一个数据 key，查询其所属的节点 id 列表
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataScore := c.hash(dataKey)
+	nodes, holders, err := c.place(ctx, snapshot, dataKey, dataScore, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 2 在这个过程中会建立这则数据与每个副本节点 id 的映射关系. 节点变更时依据副本数的上限推算需要扫描的区间，
+	// 因此需要先保证上限不低于本次的副本数
+	if err = c.ensureDataKeyReplicas(ctx, n); err != nil {
+		return nil, nil, err
+	}
+	if err = c.relocateDataKey(ctx, dataKey, nil, nodes); err != nil {
+		return nil, nil, err
+	}
+	if c.opts.boundedLoads {
+		c.addNodeLoads(snapshot.version, subtract(nodes, holders))
+	}
+
+	// 3 建立映射期间哈希环可能已经发生变更，而数据迁移可能没有覆盖到这次写入，
+	// 因此需要基于最新的快照重新定位，纠正映射关系
+	for {
+		latest, err := c.loadSnapshot(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if latest.version == snapshot.version {
+			// 4 数据 key 的映射关系记录在归属节点下，但路由需要跳过非 active 的节点
+			routed, err := c.route(snapshot, dataKey, dataScore, nodes)
+			if err != nil {
+				return nil, nil, err
+			}
+			return snapshot, routed, nil
+		}
+
+		latestNodes, _, err := c.place(ctx, latest, dataKey, dataScore, n)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = c.relocateDataKey(ctx, dataKey, nodes, latestNodes); err != nil {
+			return nil, nil, err
+		}
+
+		snapshot, nodes = latest, latestNodes
+	}
+}
+
+// 为数据 key 选择 n 个副本节点. 有界负载模式下同时返回原本就持有该数据 key 的节点
+func (c *ConsistentHash) place(ctx context.Context, snapshot *ringSnapshot, dataKey string, dataScore int64, n int) ([]string, []string, error) {
+	var nodes, holders []string
+	if c.opts.boundedLoads {
+		var err error
+		if nodes, holders, err = c.boundedPlace(ctx, snapshot, dataKey, dataScore, n); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		nodes = snapshot.locate(dataKey, dataScore, n)
+	}
 
 	if len(nodes) == 0 {
-		return "", errors.New("no node available with empty score")
+		return nil, nil, errors.New("no node available")
 	}
+	return nodes, holders, nil
+}
 
-	// 2 在这个过程中会建立这则数据与节点 id 的映射关系
-	if err = c.hashRing.AddNodeToDataKeys(ctx, c.getNodeID(nodes[0]), map[string]struct{}{
-		dataKey: {},
-	}); err != nil {
-		return "", err
+// 跳过非 active 的节点，由偏好列表中后续的 active 节点顶替. 节点状态只影响路由，不改变数据的归属
+func (c *ConsistentHash) route(snapshot *ringSnapshot, dataKey string, dataScore int64, owners []string) ([]string, error) {
+	if len(snapshot.states) == 0 {
+		return owners, nil
 	}
 
-	return nodes[0], nil
+	routed := make([]string, 0, len(owners))
+	for _, nodeID := range owners {
+		if snapshot.active(nodeID) {
+			routed = append(routed, nodeID)
+		}
+	}
+
+	if len(routed) < len(owners) {
+		for _, nodeID := range snapshot.locate(dataKey, dataScore, snapshot.nodeCount) {
+			if len(routed) == len(owners) {
+				break
+			}
+			if !snapshot.active(nodeID) || contains(owners, nodeID) {
+				continue
+			}
+			routed = append(routed, nodeID)
+		}
+	}
+
+	if len(routed) == 0 {
+		return nil, errors.New("no active node available")
+	}
+	return routed, nil
+}
+
+// 将数据 key 的映射关系由 oldNodes 调整为 newNodes
+func (c *ConsistentHash) relocateDataKey(ctx context.Context, dataKey string, oldNodes, newNodes []string) error {
+	dataKeys := map[string]struct{}{dataKey: {}}
+	for _, nodeID := range oldNodes {
+		if contains(newNodes, nodeID) {
+			continue
+		}
+		if err := c.dataKeyIndex.DeleteNodeToDataKeys(ctx, nodeID, dataKeys); err != nil {
+			return err
+		}
+	}
+
+	for _, nodeID := range newNodes {
+		if contains(oldNodes, nodeID) {
+			continue
+		}
+		if err := addDataKeys(ctx, c.dataKeyIndex, c.hash, nodeID, dataKeys); err != nil {
+			return err
+		}
+	}
+
+	if c.opts.dataKeyTTL <= 0 {
+		return nil
+	}
+	// 每次记录都顺延过期时间，包括原本就持有该数据 key 的节点
+	expireAt := time.Now().Add(c.opts.dataKeyTTL).UnixMilli()
+	for _, nodeID := range newNodes {
+		if err := c.dataKeyIndex.ExpireDataKeys(ctx, nodeID, dataKeys, expireAt); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (c *ConsistentHash) getValidWeight(weight int) int {
-	if weight <= 0 {
-		return 1
+// 将权重换算为虚拟节点个数：round(weight * replicas)，至少为 1.
+// 权重非法，或者超出单节点虚拟节点个数上限、虚拟节点总预算时返回错误
+func (c *ConsistentHash) getReplicas(nodes map[string]int, nodeID string, weight float64) (int, error) {
+	if math.IsNaN(weight) || math.IsInf(weight, 0) || weight <= 0 {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidWeight, weight)
+	}
+
+	_replicas := math.Round(weight * float64(c.opts.replicas))
+	if _replicas > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: %v, too many virtual nodes", ErrInvalidWeight, weight)
+	}
+
+	replicas := int(_replicas)
+	if replicas < 1 {
+		replicas = 1
 	}
 
-	if weight >= 10 {
-		return 10
+	if c.opts.maxVirtualNodes > 0 && replicas > c.opts.maxVirtualNodes {
+		return 0, fmt.Errorf("%w: %v, virtual nodes: %d exceed max virtual nodes per node: %d", ErrInvalidWeight, weight, replicas, c.opts.maxVirtualNodes)
 	}
 
-	return weight
+	if c.opts.virtualNodeBudget > 0 {
+		total := replicas
+		for node, _replicas := range nodes {
+			if node != nodeID {
+				total += _replicas
+			}
+		}
+		if total > c.opts.virtualNodeBudget {
+			return 0, fmt.Errorf("%w: %v, total virtual nodes: %d exceed budget: %d", ErrInvalidWeight, weight, total, c.opts.virtualNodeBudget)
+		}
+	}
+
+	return replicas, nil
 }
 
-func (c *ConsistentHash) getRawNodeKey(nodeID string, index int) string {
-	return fmt.Sprintf("%s_%d", nodeID, index)
+func contains(nodeIDs []string, nodeID string) bool {
+	for _, _nodeID := range nodeIDs {
+		if _nodeID == nodeID {
+			return true
+		}
+	}
+	return false
 }
 
-func (c *ConsistentHash) getNodeID(rawNodeKey string) string {
-	index := strings.LastIndex(rawNodeKey, "_")
-	return rawNodeKey[:index]
+// 返回 nodeIDs 中不属于 excluded 的节点
+func subtract(nodeIDs, excluded []string) []string {
+	var result []string
+	for _, nodeID := range nodeIDs {
+		if !contains(excluded, nodeID) {
+			result = append(result, nodeID)
+		}
+	}
+	return result
 }
@@ -0,0 +1,136 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 记录数据 key 与节点映射关系的时机
+type DataKeyTracking int
+
+const (
+	// 每次 GetNode/GetNodes 都记录数据 key，节点变更时迁移全部被查询过的数据. 默认模式
+	DataKeyTrackingLookup DataKeyTracking = iota
+	// 只在调用 Register 时记录数据 key，GetNode/GetNodes 不产生写操作
+	DataKeyTrackingRegister
+	// 不记录数据 key，只提供路由. 节点变更时不会调用 Migrator
+	DataKeyTrackingNone
+)
+
+// 没有记录数据 key 的实例调用 Register 时返回的错误
+var ErrDataKeyTrackingDisabled = errors.New("data key tracking disabled")
+
+// 返回数据 key 所属的节点，不会记录数据 key
+func (c *ConsistentHash) Locate(ctx context.Context, dataKey string) (string, error) {
+	nodes, err := c.LocateN(ctx, dataKey, 1)
+	if err != nil {
+		return "", err
+	}
+	return nodes[0], nil
+}
+
+// 与 GetNodes 一致，返回数据 key 的前 n 个副本节点，但不会记录数据 key
+func (c *ConsistentHash) LocateN(ctx context.Context, dataKey string, n int) ([]string, error) {
+	_, nodes, err := c.locateNodes(ctx, dataKey, n)
+	return nodes, err
+}
+
+// 记录数据 key 与前 n 个副本节点的映射关系，并返回副本节点. 节点变更时会为记录过的数据 key 调用 Migrator
+func (c *ConsistentHash) Register(ctx context.Context, dataKey string, n int) ([]string, error) {
+	if c.dataKeyIndex == nil {
+		return nil, ErrDataKeyTrackingDisabled
+	}
+	_, nodes, err := c.trackNodes(ctx, dataKey, n)
+	return nodes, err
+}
+
+// 基于快照定位数据 key，只读取哈希环，不做任何修改
+func (c *ConsistentHash) locateNodes(ctx context.Context, dataKey string, n int) (*ringSnapshot, []string, error) {
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("invalid replica count: %d", n)
+	}
+
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataScore := c.hash(dataKey)
+	nodes, _, err := c.place(ctx, snapshot, dataKey, dataScore, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	routed, err := c.route(snapshot, dataKey, dataScore, nodes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, routed, nil
+}
+
+// 从所有节点的索引中删除数据 key，通常在数据被删除后调用. 之后的节点变更不会再为该数据 key 调用 Migrator
+func (c *ConsistentHash) ForgetKey(ctx context.Context, dataKey string) error {
+	if c.dataKeyIndex == nil {
+		return ErrDataKeyTrackingDisabled
+	}
+
+	dataKeys := map[string]struct{}{dataKey: {}}
+	return c.forEachMember(ctx, func(nodeID string) error {
+		return c.dataKeyIndex.DeleteNodeToDataKeys(ctx, nodeID, dataKeys)
+	})
+}
+
+// 为已经记录的数据 key 设置过期时长，到期后数据 key 从索引中淘汰. ttl <= 0 代表取消过期时间
+func (c *ConsistentHash) ExpireKey(ctx context.Context, dataKey string, ttl time.Duration) error {
+	if c.dataKeyIndex == nil {
+		return ErrDataKeyTrackingDisabled
+	}
+
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl).UnixMilli()
+	}
+	dataKeys := map[string]struct{}{dataKey: {}}
+	return c.forEachMember(ctx, func(nodeID string) error {
+		return c.dataKeyIndex.ExpireDataKeys(ctx, nodeID, dataKeys, expireAt)
+	})
+}
+
+// 分页遍历节点下记录的数据 key. cursor 传入空字符串代表从头开始，返回的 next 为空时代表遍历结束
+func (c *ConsistentHash) ListDataKeys(ctx context.Context, nodeID, cursor string, count int) ([]string, string, error) {
+	if c.dataKeyIndex == nil {
+		return nil, "", ErrDataKeyTrackingDisabled
+	}
+	if count <= 0 {
+		return nil, "", fmt.Errorf("invalid count: %d", count)
+	}
+	return c.dataKeyIndex.ScanDataKeys(ctx, nodeID, cursor, count)
+}
+
+// 对快照中的每个物理节点执行 fn. 执行期间哈希环发生变更时，数据 key 可能已经随迁移移动到新的节点，
+// 因此需要基于最新的快照重新执行，直到哈希环的版本号不再变化
+func (c *ConsistentHash) forEachMember(ctx context.Context, fn func(nodeID string) error) error {
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		for _, nodeID := range snapshot.members {
+			if err = fn(nodeID); err != nil {
+				return err
+			}
+		}
+
+		latest, err := c.loadSnapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if latest.version == snapshot.version {
+			return nil
+		}
+		snapshot = latest
+	}
+}
@@ -0,0 +1,211 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_data_key_tracking(t *testing.T) {
+	ctx := context.Background()
+	var migrations int64
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		atomic.AddInt64(&migrations, int64(len(dataKeys)))
+		return nil
+	}
+
+	countDataKeys := func(index DataKeyIndex, nodeIDs ...string) int {
+		var total int
+		for _, nodeID := range nodeIDs {
+			count, err := index.DataKeysCount(ctx, nodeID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += count
+		}
+		return total
+	}
+
+	// 不记录数据 key，只提供路由
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithDataKeyTracking(DataKeyTrackingNone))
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := consistentHash.Register(ctx, "data_0", 1); !errors.Is(err, ErrDataKeyTrackingDisabled) {
+		t.Fatalf("expect tracking disabled, got: %v", err)
+	}
+	if count := countDataKeys(hashRing, "node_a"); count != 0 {
+		t.Fatalf("expect no data keys, got: %d", count)
+	}
+	if report, err := consistentHash.AddNode(ctx, "node_b", 1); err != nil || report.KeyCount() != 0 {
+		t.Fatalf("expect no migrations, report: %+v, err: %v", report, err)
+	}
+
+	// 只在 Register 时记录数据 key，并使用独立的索引
+	hashRing, dataKeyIndex := local.NewSkiplistHashRing(), local.NewDataKeyIndex()
+	consistentHash = NewConsistentHash(hashRing, NewMurmurHasher(), migrator,
+		WithDataKeyTracking(DataKeyTrackingRegister), WithDataKeyIndex(dataKeyIndex))
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			continue
+		}
+		nodes, err := consistentHash.Register(ctx, dataKey, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes[0] != node {
+			t.Fatalf("data key: %s, register node: %s, lookup node: %s", dataKey, nodes[0], node)
+		}
+	}
+	if count := countDataKeys(dataKeyIndex, "node_a"); count != 50 {
+		t.Fatalf("expect 50 registered data keys, got: %d", count)
+	}
+	if count := countDataKeys(hashRing, "node_a"); count != 0 {
+		t.Fatalf("expect no data keys in ring, got: %d", count)
+	}
+
+	report, err := consistentHash.AddNode(ctx, "node_b", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.KeyCount() == 0 || report.KeyCount() != countDataKeys(dataKeyIndex, "node_b") {
+		t.Fatalf("unexpected migrations: %d", report.KeyCount())
+	}
+	if count := countDataKeys(dataKeyIndex, "node_a", "node_b"); count != 50 {
+		t.Fatalf("expect 50 registered data keys, got: %d", count)
+	}
+
+	// 默认模式下 GetNode 记录数据 key，Locate 不记录
+	hashRing = local.NewSkiplistHashRing()
+	consistentHash = NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	if _, err = consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = consistentHash.Locate(ctx, "data_0"); err != nil {
+		t.Fatal(err)
+	}
+	if count := countDataKeys(hashRing, "node_a"); count != 0 {
+		t.Fatalf("locate should not track data keys, got: %d", count)
+	}
+	if _, err = consistentHash.GetNode(ctx, "data_0"); err != nil {
+		t.Fatal(err)
+	}
+	if count := countDataKeys(hashRing, "node_a"); count != 1 {
+		t.Fatalf("expect 1 data key, got: %d", count)
+	}
+}
+
+func Test_data_key_lifecycle(t *testing.T) {
+	ctx := context.Background()
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 25; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 分页遍历，每个数据 key 恰好出现一次
+	listed := make(map[string]struct{})
+	var cursor string
+	for pages := 0; ; pages++ {
+		if pages > 25 {
+			t.Fatal("list data keys does not terminate")
+		}
+		dataKeys, next, err := consistentHash.ListDataKeys(ctx, "node_a", cursor, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dataKeys) > 10 {
+			t.Fatalf("page size exceeds count: %d", len(dataKeys))
+		}
+		for _, dataKey := range dataKeys {
+			if _, ok := listed[dataKey]; ok {
+				t.Fatalf("data key: %s listed twice", dataKey)
+			}
+			listed[dataKey] = struct{}{}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(listed) != 25 {
+		t.Fatalf("expect 25 listed data keys, got: %d", len(listed))
+	}
+
+	// 注销的数据 key 不再出现在任何节点下
+	if err := consistentHash.ForgetKey(ctx, "data_0"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hashRing.HasDataKey(ctx, "node_a", "data_0"); err != nil || ok {
+		t.Fatalf("expect data_0 forgotten, ok: %v, err: %v", ok, err)
+	}
+
+	// 过期时间随迁移一同移动
+	if err := consistentHash.ExpireKey(ctx, "data_1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	expireAts, err := hashRing.DataKeysExpireAt(ctx, "node_a", map[string]struct{}{"data_1": {}})
+	if err != nil || expireAts["data_1"] == 0 {
+		t.Fatalf("expect data_1 expiring, expireAts: %v, err: %v", expireAts, err)
+	}
+	if _, err = consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	owner, err := consistentHash.Locate(ctx, "data_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := hashRing.DataKeysExpireAt(ctx, owner, map[string]struct{}{"data_1": {}})
+	if err != nil || moved["data_1"] != expireAts["data_1"] {
+		t.Fatalf("expect expiry kept on %s, got: %v, err: %v", owner, moved, err)
+	}
+
+	// 过期的数据 key 从索引中淘汰
+	consistentHash = NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), migrator, WithDataKeyTTL(20*time.Millisecond))
+	if _, err = consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = consistentHash.GetNode(ctx, "data_0"); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := consistentHash.dataKeyIndex.DataKeysCount(ctx, "node_a"); count != 1 {
+		t.Fatalf("expect 1 data key, got: %d", count)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if count, _ := consistentHash.dataKeyIndex.DataKeysCount(ctx, "node_a"); count != 0 {
+		t.Fatalf("expect expired data key purged, got: %d", count)
+	}
+
+	consistentHash = NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), migrator, WithDataKeyTracking(DataKeyTrackingNone))
+	if err = consistentHash.ForgetKey(ctx, "data_0"); !errors.Is(err, ErrDataKeyTrackingDisabled) {
+		t.Fatalf("expect tracking disabled, got: %v", err)
+	}
+}
@@ -1,7 +1,15 @@
 package consistent_hash
 
 import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
 	"math"
+	"math/bits"
+	"sort"
+	"sync"
 
 	"github.com/spaolacci/murmur3"
 )
@@ -10,6 +18,19 @@ type Encryptor interface {
 	Encrypt(origin string) int32
 }
 
+// 具备稳定名称的 Encryptor. 哈希环会记录所使用的 Encryptor 名称，拒绝以不同的 Encryptor 访问同一个哈希环
+type NamedEncryptor interface {
+	Encryptor
+	Name() string
+}
+
+// 64 位哈希空间的 Encryptor. 实现了该接口的 Encryptor 会在 [0, MaxInt64) 的空间内放置虚拟节点与数据 key，
+// 相比 31 位的哈希空间可以显著降低碰撞的概率
+type Encryptor64 interface {
+	Encryptor
+	Encrypt64(origin string) int64
+}
+
 type MurmurHasher struct {
 }
 
@@ -17,8 +38,340 @@ func NewMurmurHasher() *MurmurHasher {
 	return &MurmurHasher{}
 }
 
+func (m *MurmurHasher) Name() string {
+	return "murmur3"
+}
+
 func (m *MurmurHasher) Encrypt(origin string) int32 {
 	hasher := murmur3.New32()
 	_, _ = hasher.Write([]byte(origin))
 	return int32(hasher.Sum32() % math.MaxInt32)
 }
+
+type MurmurHasher64 struct {
+	MurmurHasher
+}
+
+func NewMurmurHasher64() *MurmurHasher64 {
+	return &MurmurHasher64{}
+}
+
+func (m *MurmurHasher64) Name() string {
+	return "murmur3_64"
+}
+
+func (m *MurmurHasher64) Encrypt64(origin string) int64 {
+	return int64(murmur3.Sum64([]byte(origin)) % math.MaxInt64)
+}
+
+// 计算 origin 在哈希环上的 score. Encryptor 支持 64 位时使用 64 位的哈希空间
+func (c *ConsistentHash) hash(origin string) int64 {
+	if encryptor, ok := c.encryptor.(Encryptor64); ok {
+		return encryptor.Encrypt64(origin)
+	}
+	return int64(c.encryptor.Encrypt(origin))
+}
+
+// 哈希空间的大小，score 的取值范围为 [0, hashSpace)
+func (c *ConsistentHash) hashSpace() int64 {
+	if _, ok := c.encryptor.(Encryptor64); ok {
+		return math.MaxInt64
+	}
+	return math.MaxInt32
+}
+
+// xxHash64，seed 为 0. 速度快，适合对性能敏感的场景
+type XXHasher struct {
+}
+
+func NewXXHasher() *XXHasher {
+	return &XXHasher{}
+}
+
+func (x *XXHasher) Name() string {
+	return "xxhash64"
+}
+
+func (x *XXHasher) Encrypt(origin string) int32 {
+	return int32(xxhash64([]byte(origin)) % math.MaxInt32)
+}
+
+func (x *XXHasher) Encrypt64(origin string) int64 {
+	return int64(xxhash64([]byte(origin)) % math.MaxInt64)
+}
+
+// 32 位的 FNV-1a
+type FNV1aHasher struct {
+}
+
+func NewFNV1aHasher() *FNV1aHasher {
+	return &FNV1aHasher{}
+}
+
+func (f *FNV1aHasher) Name() string {
+	return "fnv1a"
+}
+
+func (f *FNV1aHasher) Encrypt(origin string) int32 {
+	hasher := fnv.New32a()
+	_, _ = hasher.Write([]byte(origin))
+	return int32(hasher.Sum32() % math.MaxInt32)
+}
+
+// 64 位的 FNV-1a
+type FNV1aHasher64 struct {
+}
+
+func NewFNV1aHasher64() *FNV1aHasher64 {
+	return &FNV1aHasher64{}
+}
+
+func (f *FNV1aHasher64) Name() string {
+	return "fnv1a_64"
+}
+
+func (f *FNV1aHasher64) Encrypt(origin string) int32 {
+	return int32(f.sum64(origin) % math.MaxInt32)
+}
+
+func (f *FNV1aHasher64) Encrypt64(origin string) int64 {
+	return int64(f.sum64(origin) % math.MaxInt64)
+}
+
+func (f *FNV1aHasher64) sum64(origin string) uint64 {
+	hasher := fnv.New64a()
+	_, _ = hasher.Write([]byte(origin))
+	return hasher.Sum64()
+}
+
+// IEEE 多项式的 CRC32，与多数语言标准库的 crc32 实现一致
+type CRC32Hasher struct {
+}
+
+func NewCRC32Hasher() *CRC32Hasher {
+	return &CRC32Hasher{}
+}
+
+func (c *CRC32Hasher) Name() string {
+	return "crc32"
+}
+
+func (c *CRC32Hasher) Encrypt(origin string) int32 {
+	return int32(crc32.ChecksumIEEE([]byte(origin)) % math.MaxInt32)
+}
+
+// SipHash-2-4. 使用密钥的 SipHash 可以抵御针对哈希碰撞的攻击，
+// 不同密钥对应不同的名称，名称中只包含密钥的指纹，不会泄露密钥
+type SipHasher struct {
+	k0, k1 uint64
+}
+
+// 使用全零密钥
+func NewSipHasher() *SipHasher {
+	return &SipHasher{}
+}
+
+func NewKeyedSipHasher(key [16]byte) *SipHasher {
+	return &SipHasher{
+		k0: binary.LittleEndian.Uint64(key[:8]),
+		k1: binary.LittleEndian.Uint64(key[8:]),
+	}
+}
+
+func (s *SipHasher) Name() string {
+	if s.k0 == 0 && s.k1 == 0 {
+		return "siphash"
+	}
+	return fmt.Sprintf("siphash_%08x", uint32(sipHash24(s.k0, s.k1, []byte("consistent_hash"))))
+}
+
+func (s *SipHasher) Encrypt(origin string) int32 {
+	return int32(sipHash24(s.k0, s.k1, []byte(origin)) % math.MaxInt32)
+}
+
+func (s *SipHasher) Encrypt64(origin string) int64 {
+	return int64(sipHash24(s.k0, s.k1, []byte(origin)) % math.MaxInt64)
+}
+
+// 与 libketama 相同的 key 哈希：取 md5 摘要的前 4 个字节，按小端序组成 32 位整数
+type MD5KetamaHasher struct {
+}
+
+func NewMD5KetamaHasher() *MD5KetamaHasher {
+	return &MD5KetamaHasher{}
+}
+
+func (m *MD5KetamaHasher) Name() string {
+	return "md5_ketama"
+}
+
+func (m *MD5KetamaHasher) Encrypt(origin string) int32 {
+	digest := md5.Sum([]byte(origin))
+	return int32(binary.LittleEndian.Uint32(digest[:4]) % math.MaxInt32)
+}
+
+var (
+	encryptorMutex sync.RWMutex
+	encryptors     = map[string]func() Encryptor{
+		"murmur3":    func() Encryptor { return NewMurmurHasher() },
+		"murmur3_64": func() Encryptor { return NewMurmurHasher64() },
+		"xxhash64":   func() Encryptor { return NewXXHasher() },
+		"fnv1a":      func() Encryptor { return NewFNV1aHasher() },
+		"fnv1a_64":   func() Encryptor { return NewFNV1aHasher64() },
+		"crc32":      func() Encryptor { return NewCRC32Hasher() },
+		"siphash":    func() Encryptor { return NewSipHasher() },
+		"md5_ketama": func() Encryptor { return NewMD5KetamaHasher() },
+	}
+)
+
+// 注册自定义的 Encryptor，之后可以通过名称构造. 名称重复时返回错误
+func RegisterEncryptor(name string, newEncryptor func() Encryptor) error {
+	encryptorMutex.Lock()
+	defer encryptorMutex.Unlock()
+	if _, ok := encryptors[name]; ok {
+		return fmt.Errorf("repeat encryptor: %s", name)
+	}
+	encryptors[name] = newEncryptor
+	return nil
+}
+
+// 通过名称构造 Encryptor，例如使用哈希环记录的名称构造出一致的 Encryptor
+func NewEncryptor(name string) (Encryptor, error) {
+	encryptorMutex.RLock()
+	defer encryptorMutex.RUnlock()
+	newEncryptor, ok := encryptors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryptor: %s", name)
+	}
+	return newEncryptor(), nil
+}
+
+// 已注册的 Encryptor 名称，升序排列
+func EncryptorNames() []string {
+	encryptorMutex.RLock()
+	defer encryptorMutex.RUnlock()
+	names := make([]string, 0, len(encryptors))
+	for name := range encryptors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Encryptor 的名称. 未实现 NamedEncryptor 的 Encryptor 使用其类型名
+func encryptorName(encryptor Encryptor) string {
+	if named, ok := encryptor.(NamedEncryptor); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", encryptor)
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func xxhash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		prime1, prime2 := xxPrime1, xxPrime2
+		v1, v2, v3, v4 := prime1+prime2, prime2, uint64(0), -prime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+
+	h += uint64(n)
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+func sipHash24(k0, k1 uint64, m []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	last := uint64(len(m)) << 56
+	for ; len(m) >= 8; m = m[8:] {
+		word := binary.LittleEndian.Uint64(m[:8])
+		v3 ^= word
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= word
+	}
+	for i, c := range m {
+		last |= uint64(c) << (8 * i)
+	}
+
+	v3 ^= last
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= last
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
@@ -0,0 +1,114 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_encryptor_vectors(t *testing.T) {
+	for origin, expect := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"abc": 0x44bc2cf5ad770999,
+	} {
+		if got := xxhash64([]byte(origin)); got != expect {
+			t.Fatalf("xxhash64 of %q, expect: %x, got: %x", origin, expect, got)
+		}
+	}
+
+	// SipHash 论文附录中的测试向量
+	key, message := make([]byte, 16), make([]byte, 15)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range message {
+		message[i] = byte(i)
+	}
+	var _key [16]byte
+	copy(_key[:], key)
+	sipHasher := NewKeyedSipHasher(_key)
+	if got := sipHash24(sipHasher.k0, sipHasher.k1, message); got != 0xa129ca6149be45e5 {
+		t.Fatalf("siphash, got: %x", got)
+	}
+}
+
+func Test_encryptor_registry(t *testing.T) {
+	for _, name := range EncryptorNames() {
+		encryptor, err := NewEncryptor(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := encryptorName(encryptor); got != name {
+			t.Fatalf("expect name: %s, got: %s", name, got)
+		}
+		if score := encryptor.Encrypt("data"); score < 0 {
+			t.Fatalf("encryptor: %s, negative score: %d", name, score)
+		}
+		if encryptor64, ok := encryptor.(Encryptor64); ok && encryptor64.Encrypt64("data") < 0 {
+			t.Fatalf("encryptor: %s, negative score", name)
+		}
+	}
+
+	if _, err := NewEncryptor("unknown"); err == nil {
+		t.Fatal("expect error of unknown encryptor")
+	}
+	if err := RegisterEncryptor("murmur3", func() Encryptor { return NewMurmurHasher() }); err == nil {
+		t.Fatal("expect error of repeat encryptor")
+	}
+}
+
+func Test_ring_config_mismatch(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 更换 Encryptor 会改变全部数据的归属，需要被拒绝
+	switched := NewConsistentHash(hashRing, NewXXHasher(), nil)
+	if _, err := switched.AddNode(ctx, "node_b", 1); !errors.Is(err, ErrRingConfigMismatch) {
+		t.Fatalf("expect ring config mismatch, got: %v", err)
+	}
+	if _, err := switched.GetNode(ctx, "data"); !errors.Is(err, ErrRingConfigMismatch) {
+		t.Fatalf("expect ring config mismatch, got: %v", err)
+	}
+
+	// 使用相同名称的 Encryptor 则可以正常访问
+	encryptor, err := NewEncryptor("murmur3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewConsistentHash(hashRing, encryptor, nil).AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Benchmark_encryptor(b *testing.B) {
+	dataKeys := make([]string, 1024)
+	for i := range dataKeys {
+		dataKeys[i] = fmt.Sprintf("user:%d:profile", i)
+	}
+
+	for _, name := range EncryptorNames() {
+		encryptor, _ := NewEncryptor(name)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = encryptor.Encrypt(dataKeys[i%len(dataKeys)])
+			}
+		})
+
+		encryptor64, ok := encryptor.(Encryptor64)
+		if !ok {
+			continue
+		}
+		b.Run(name+"/64", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = encryptor64.Encrypt64(dataKeys[i%len(dataKeys)])
+			}
+		})
+	}
+}
@@ -46,17 +46,17 @@ func Test_redis_consistent_hash(t *testing.T) {
 func test(t *testing.T, consistentHash *ConsistentHash) {
 	ctx := context.Background()
 	nodeA := "node_a"
-	weightNodeA := 2
+	weightNodeA := 2.0
 	nodeB := "node_b"
-	weightNodeB := 1
+	weightNodeB := 1.0
 	nodeC := "node_c"
-	weightNodeC := 1
-	if err := consistentHash.AddNode(ctx, nodeA, weightNodeA); err != nil {
+	weightNodeC := 1.0
+	if _, err := consistentHash.AddNode(ctx, nodeA, weightNodeA); err != nil {
 		t.Error(err)
 		return
 	}
 
-	if err := consistentHash.AddNode(ctx, nodeB, weightNodeB); err != nil {
+	if _, err := consistentHash.AddNode(ctx, nodeB, weightNodeB); err != nil {
 		t.Error(err)
 		return
 	}
@@ -86,7 +86,7 @@ func test(t *testing.T, consistentHash *ConsistentHash) {
 		return
 	}
 	t.Logf("data: %s belongs to node: %s", dataKeyD, node)
-	if err := consistentHash.AddNode(ctx, nodeC, weightNodeC); err != nil {
+	if _, err := consistentHash.AddNode(ctx, nodeC, weightNodeC); err != nil {
 		t.Error(err)
 		return
 	}
@@ -110,7 +110,7 @@ func test(t *testing.T, consistentHash *ConsistentHash) {
 		return
 	}
 	t.Logf("data: %s belongs to node: %s", dataKeyD, node)
-	if err = consistentHash.RemoveNode(ctx, nodeC); err != nil {
+	if _, err = consistentHash.RemoveNode(ctx, nodeC); err != nil {
 		t.Error(err)
 		return
 	}
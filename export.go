@@ -0,0 +1,407 @@
+package consistent_hash
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 哈希环导出的格式
+type ExportFormat int
+
+const (
+	ExportFormatJSON ExportFormat = iota
+	// 基于 gob 的紧凑二进制格式，以 ringDumpMagic 开头
+	ExportFormatBinary
+)
+
+// 导出数据的格式版本，格式发生不兼容的变化时递增
+const ringDumpVersion = 2
+
+// 二进制格式的文件头
+var ringDumpMagic = []byte("CHRING")
+
+// 哈希环的完整导出数据
+type RingDump struct {
+	FormatVersion int        `json:"format_version"`
+	Config        RingConfig `json:"config"`
+	// 导出时哈希环的版本号
+	Version int64 `json:"version"`
+	// 每个节点对应的虚拟节点个数
+	Nodes map[string]int `json:"nodes"`
+	// 每个节点序列化后的元数据，以及处于非 active 状态的节点
+	Metas  map[string]string `json:"metas,omitempty"`
+	States map[string]string `json:"states,omitempty"`
+	// 虚拟节点表，key 为 virtualScore，val 为该 score 下每个节点的虚拟节点下标
+	VirtualNodes map[int64]map[string][]int `json:"virtual_nodes"`
+	// 每个节点下的数据 key，升序排列
+	DataKeys map[string][]string `json:"data_keys,omitempty"`
+	// 设置了过期时间的数据 key 及其过期时间（unix 毫秒）
+	DataKeyExpireAts map[string]map[string]int64 `json:"data_key_expire_ats,omitempty"`
+}
+
+// 哈希环的配置. 导入时 Encryptor 与放置策略必须与当前实例一致，否则全部数据的归属都会发生变化
+type RingConfig struct {
+	Encryptor string `json:"encryptor"`
+	Placement string `json:"placement"`
+	Replicas  int    `json:"replicas"`
+	// 虚拟节点的命名方式，为空时代表默认的命名方式
+	VnodeKeyer string `json:"vnode_keyer,omitempty"`
+}
+
+// 当前实例的配置
+func (c *ConsistentHash) ringConfig() RingConfig {
+	return RingConfig{
+		Encryptor:  encryptorName(c.encryptor),
+		Placement:  c.placement.name(),
+		Replicas:   c.opts.replicas,
+		VnodeKeyer: c.opts.vnodeKeyer.Name(),
+	}
+}
+
+// Encryptor 与放置策略一致时，相同的哈希环拓扑会得到相同的数据归属
+func (r RingConfig) compatible(other RingConfig) bool {
+	return r.Encryptor == other.Encryptor && r.Placement == other.Placement && r.vnodeKeyer() == other.vnodeKeyer()
+}
+
+// 早于命名方式可配置时记录的配置不包含命名方式，视为默认的命名方式
+func (r RingConfig) vnodeKeyer() string {
+	if r.VnodeKeyer == "" {
+		return DefaultVnodeKeyer{}.Name()
+	}
+	return r.VnodeKeyer
+}
+
+// 哈希环记录的配置与当前实例的配置不一致. 以不同的 Encryptor 或放置策略访问同一个哈希环会导致全部数据的归属发生变化
+var ErrRingConfigMismatch = errors.New("ring config mismatch")
+
+// 读取哈希环记录的配置，与当前实例的配置进行比对. 哈希环尚未记录配置时视为一致
+func (c *ConsistentHash) checkRingConfig(ctx context.Context) error {
+	stored, err := c.loadRingConfig(ctx)
+	if err != nil || stored == nil {
+		return err
+	}
+	if config := c.ringConfig(); !stored.compatible(config) {
+		return fmt.Errorf("%w, ring: %+v, current: %+v", ErrRingConfigMismatch, *stored, config)
+	}
+	return nil
+}
+
+// 读取哈希环记录的配置，尚未记录时返回 nil
+func (c *ConsistentHash) loadRingConfig(ctx context.Context) (*RingConfig, error) {
+	rawConfig, err := c.hashRing.RingConfig(ctx)
+	if err != nil || rawConfig == "" {
+		return nil, err
+	}
+
+	var config RingConfig
+	if err = json.Unmarshal([]byte(rawConfig), &config); err != nil {
+		return nil, fmt.Errorf("invalid ring config: %s, err: %w", rawConfig, err)
+	}
+	return &config, nil
+}
+
+// 哈希环首次变更时记录当前实例的配置
+func (c *ConsistentHash) recordRingConfig(ctx context.Context, tx *ringTx) error {
+	rawConfig, err := c.hashRing.RingConfig(ctx)
+	if err != nil || rawConfig != "" {
+		return err
+	}
+
+	config, _ := json.Marshal(c.ringConfig())
+	return tx.SetRingConfig(ctx, string(config))
+}
+
+// 导出哈希环的完整状态，用于备份、跨环境迁移以及构造测试数据
+func (c *ConsistentHash) Export(ctx context.Context, w io.Writer, format ExportFormat) error {
+	// 加锁，保证导出的是一份一致的哈希环
+	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	dump, err := c.dumpRing(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ExportFormatJSON:
+		return json.NewEncoder(w).Encode(dump)
+	case ExportFormatBinary:
+		if _, err = w.Write(ringDumpMagic); err != nil {
+			return err
+		}
+		return gob.NewEncoder(w).Encode(dump)
+	default:
+		return fmt.Errorf("invalid export format: %d", format)
+	}
+}
+
+func (c *ConsistentHash) dumpRing(ctx context.Context) (*RingDump, error) {
+	dump := RingDump{
+		FormatVersion: ringDumpVersion,
+		Config:        c.ringConfig(),
+		Nodes:         make(map[string]int),
+		DataKeys:      make(map[string][]string),
+	}
+
+	var err error
+	if dump.Version, err = c.hashRing.Version(ctx); err != nil {
+		return nil, err
+	}
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for nodeID, replicas := range nodes {
+		dump.Nodes[nodeID] = replicas
+	}
+
+	if dump.Metas, err = c.hashRing.NodeMetas(ctx); err != nil {
+		return nil, err
+	}
+	if dump.States, err = c.hashRing.NodeStates(ctx); err != nil {
+		return nil, err
+	}
+	if dump.VirtualNodes, err = c.hashRing.VirtualNodes(ctx); err != nil {
+		return nil, err
+	}
+
+	// 没有记录数据 key 时，只导出哈希环的拓扑
+	if c.dataKeyIndex == nil {
+		return &dump, nil
+	}
+
+	for nodeID := range nodes {
+		dataKeys, err := c.dataKeyIndex.DataKeys(ctx, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if len(dataKeys) == 0 {
+			continue
+		}
+
+		sortedKeys := make([]string, 0, len(dataKeys))
+		for dataKey := range dataKeys {
+			sortedKeys = append(sortedKeys, dataKey)
+		}
+		sort.Strings(sortedKeys)
+		dump.DataKeys[nodeID] = sortedKeys
+
+		expireAts, err := c.dataKeyIndex.DataKeysExpireAt(ctx, nodeID, dataKeys)
+		if err != nil {
+			return nil, err
+		}
+		if len(expireAts) == 0 {
+			continue
+		}
+		if dump.DataKeyExpireAts == nil {
+			dump.DataKeyExpireAts = make(map[string]map[string]int64)
+		}
+		dump.DataKeyExpireAts[nodeID] = expireAts
+	}
+	return &dump, nil
+}
+
+// 读取 Export 导出的数据，格式根据文件头自动识别. 旧版本格式的数据会被转换为当前格式
+func ReadRingDump(r io.Reader) (*RingDump, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	binary := bytes.HasPrefix(raw, ringDumpMagic)
+	decode := func(v interface{}) error {
+		if binary {
+			return gob.NewDecoder(bytes.NewReader(raw[len(ringDumpMagic):])).Decode(v)
+		}
+		return json.Unmarshal(raw, v)
+	}
+
+	// 先读取格式版本，再按照对应版本的结构解析
+	var header struct {
+		FormatVersion int `json:"format_version"`
+	}
+	if err = decode(&header); err != nil {
+		return nil, fmt.Errorf("invalid ring dump, err: %w", err)
+	}
+
+	switch header.FormatVersion {
+	case ringDumpVersion:
+		var dump RingDump
+		if err = decode(&dump); err != nil {
+			return nil, fmt.Errorf("invalid ring dump, err: %w", err)
+		}
+		return &dump, nil
+	case 1:
+		var dump ringDumpV1
+		if err = decode(&dump); err != nil {
+			return nil, fmt.Errorf("invalid ring dump, err: %w", err)
+		}
+		return dump.upgrade()
+	default:
+		return nil, fmt.Errorf("unsupported ring dump format version: %d", header.FormatVersion)
+	}
+}
+
+// 格式版本 1 的导出数据，虚拟节点以 "nodeID_index" 形式的 key 存储
+type ringDumpV1 struct {
+	FormatVersion int                 `json:"format_version"`
+	Config        RingConfig          `json:"config"`
+	Version       int64               `json:"version"`
+	Nodes         map[string]int      `json:"nodes"`
+	Metas         map[string]string   `json:"metas,omitempty"`
+	States        map[string]string   `json:"states,omitempty"`
+	VirtualNodes  map[int64][]string  `json:"virtual_nodes"`
+	DataKeys      map[string][]string `json:"data_keys,omitempty"`
+}
+
+// 将虚拟节点 key 解析为 (nodeID, index)，转换为当前格式
+func (d *ringDumpV1) upgrade() (*RingDump, error) {
+	dump := RingDump{
+		FormatVersion: ringDumpVersion,
+		Config:        d.Config,
+		Version:       d.Version,
+		Nodes:         d.Nodes,
+		Metas:         d.Metas,
+		States:        d.States,
+		VirtualNodes:  make(map[int64]map[string][]int, len(d.VirtualNodes)),
+		DataKeys:      d.DataKeys,
+	}
+	for virtualScore, nodeKeys := range d.VirtualNodes {
+		nodes := make(map[string][]int, len(nodeKeys))
+		for _, nodeKey := range nodeKeys {
+			sep := strings.LastIndex(nodeKey, "_")
+			if sep < 0 {
+				return nil, fmt.Errorf("invalid virtual node key: %s", nodeKey)
+			}
+			index, err := strconv.Atoi(nodeKey[sep+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid virtual node key: %s, err: %w", nodeKey, err)
+			}
+			nodeID := nodeKey[:sep]
+			nodes[nodeID] = append(nodes[nodeID], index)
+		}
+		dump.VirtualNodes[virtualScore] = nodes
+	}
+	return &dump, nil
+}
+
+// 将 Export 导出的数据导入到当前实例的哈希环中，目标哈希环必须为空.
+// 导入是原子的，中途失败时会回滚全部写入. 导入不会触发数据迁移
+func (c *ConsistentHash) Import(ctx context.Context, r io.Reader) error {
+	dump, err := ReadRingDump(r)
+	if err != nil {
+		return err
+	}
+
+	if config := c.ringConfig(); !dump.Config.compatible(config) {
+		return fmt.Errorf("%w, dump: %+v, current: %+v", ErrRingConfigMismatch, dump.Config, config)
+	}
+
+	if err = c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 0 {
+		return errors.New("import into a non-empty ring")
+	}
+	// 目标哈希环清空后依然保留着此前记录的配置，需要与当前实例一致
+	if err = c.checkRingConfig(ctx); err != nil {
+		return err
+	}
+
+	_, err = c.commitMeta(ctx, func(tx *ringTx) error {
+		return c.loadRing(ctx, tx, dump)
+	})
+	return err
+}
+
+func (c *ConsistentHash) loadRing(ctx context.Context, tx *ringTx, dump *RingDump) error {
+	for nodeID, replicas := range dump.Nodes {
+		if err := tx.AddNodeToReplica(ctx, nodeID, replicas); err != nil {
+			return err
+		}
+	}
+
+	for nodeID, meta := range dump.Metas {
+		if err := tx.SetNodeMeta(ctx, nodeID, meta); err != nil {
+			return err
+		}
+	}
+
+	for nodeID, state := range dump.States {
+		if err := tx.SetNodeState(ctx, nodeID, state); err != nil {
+			return err
+		}
+	}
+
+	for virtualScore, nodes := range dump.VirtualNodes {
+		for nodeID, indexes := range nodes {
+			for _, index := range indexes {
+				if err := tx.Add(ctx, ringVirtualNode{score: virtualScore, nodeID: nodeID, index: index}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// 当前实例不记录数据 key 时，忽略导出数据中的数据 key
+	if c.dataKeyIndex == nil {
+		return nil
+	}
+
+	// 一个数据 key 被记录在几个节点下，就拥有几个副本
+	holders := make(map[string]int)
+	var maxReplicas int
+	for _, dataKeys := range dump.DataKeys {
+		for _, dataKey := range dataKeys {
+			if holders[dataKey]++; holders[dataKey] > maxReplicas {
+				maxReplicas = holders[dataKey]
+			}
+		}
+	}
+	// 上限只增不减，偏大只会多扫描一些区间，回滚时无需恢复
+	if err := c.hashRing.RaiseDataKeyReplicas(ctx, maxReplicas); err != nil {
+		return err
+	}
+
+	for nodeID, dataKeys := range dump.DataKeys {
+		if _, ok := dump.Nodes[nodeID]; !ok {
+			return fmt.Errorf("data keys of unknown node: %s", nodeID)
+		}
+
+		_dataKeys := make(map[string]struct{}, len(dataKeys))
+		for _, dataKey := range dataKeys {
+			_dataKeys[dataKey] = struct{}{}
+		}
+		if err := tx.AddNodeToDataKeys(ctx, nodeID, _dataKeys); err != nil {
+			return err
+		}
+		// 回滚时数据 key 会被整体删除，过期时间无需单独回滚
+		if err := expireDataKeys(ctx, c.dataKeyIndex, nodeID, dump.DataKeyExpireAts[nodeID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package consistent_hash
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_export_import(t *testing.T) {
+	ctx := context.Background()
+	source := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(source, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	})
+
+	if _, err := consistentHash.AddNode(ctx, "node_a", 2, WithNodeMeta(NodeMeta{Address: "10.0.0.1:6379"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.SetNodeState(ctx, "node_b", NodeStateDraining); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []ExportFormat{ExportFormatJSON, ExportFormatBinary} {
+		var buf bytes.Buffer
+		if err := consistentHash.Export(ctx, &buf, format); err != nil {
+			t.Fatal(err)
+		}
+		exported := buf.Bytes()
+
+		target := local.NewSkiplistHashRing()
+		imported := NewConsistentHash(target, NewMurmurHasher(), nil)
+		if err := imported.Import(ctx, bytes.NewReader(exported)); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect, got := dumpRingState(t, source), dumpRingState(t, target); !reflect.DeepEqual(expect, got) {
+			t.Fatalf("format: %d expect ring: %+v, got: %+v", format, expect, got)
+		}
+		sourceMetas, _ := source.NodeMetas(ctx)
+		targetMetas, _ := target.NodeMetas(ctx)
+		sourceStates, _ := source.NodeStates(ctx)
+		targetStates, _ := target.NodeStates(ctx)
+		if !reflect.DeepEqual(sourceMetas, targetMetas) || !reflect.DeepEqual(sourceStates, targetStates) {
+			t.Fatalf("format: %d node metas or states mismatch", format)
+		}
+
+		for i := 0; i < 100; i++ {
+			dataKey := fmt.Sprintf("data_%d", i)
+			expect, _ := consistentHash.GetNode(ctx, dataKey)
+			got, _ := imported.GetNode(ctx, dataKey)
+			if expect != got {
+				t.Fatalf("data: %s expect node: %s, got: %s", dataKey, expect, got)
+			}
+		}
+
+		// 目标哈希环非空时拒绝导入
+		if err := imported.Import(ctx, bytes.NewReader(exported)); err == nil {
+			t.Fatal("expect error when importing into a non-empty ring")
+		}
+
+		// 放置策略不一致时拒绝导入
+		jump := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), nil, WithJumpHash())
+		if err := jump.Import(ctx, bytes.NewReader(exported)); err == nil {
+			t.Fatal("expect error when ring config mismatch")
+		}
+
+		// 目标哈希环已经清空，但记录的配置与当前实例不一致时拒绝导入
+		cleared := local.NewSkiplistHashRing()
+		previous := NewConsistentHash(cleared, NewMurmurHasher(), nil, WithJumpHash())
+		if _, err := previous.AddNode(ctx, "node_a", 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := previous.RemoveNode(ctx, "node_a"); err != nil {
+			t.Fatal(err)
+		}
+		version, _ := cleared.Version(ctx)
+		if err := NewConsistentHash(cleared, NewMurmurHasher(), nil).Import(ctx, bytes.NewReader(exported)); !errors.Is(err, ErrRingConfigMismatch) {
+			t.Fatalf("expect ring config mismatch, got: %v", err)
+		}
+		// 导入前即被拒绝，不会写入哈希环
+		if got, _ := cleared.Version(ctx); got != version {
+			t.Fatalf("expect version: %d, got: %d", version, got)
+		}
+	}
+}
+
+// 格式版本 1 的导出数据，节点 node_a 权重为 2，node_b 权重为 1，WithReplicas(2)
+const ringDumpV1Fixture = `{
+	"format_version": 1,
+	"config": {"encryptor": "murmur3", "placement": "ring", "replicas": 2},
+	"version": 2,
+	"nodes": {"node_a": 4, "node_b": 2},
+	"virtual_nodes": {
+		"872362280": ["node_a_0"],
+		"232683621": ["node_a_1"],
+		"1515479869": ["node_a_2"],
+		"1449501590": ["node_a_3"],
+		"876392497": ["node_b_0"],
+		"836975022": ["node_b_1"]
+	},
+	"data_keys": {"node_a": ["data_0"]}
+}`
+
+func Test_import_v1_dump(t *testing.T) {
+	ctx := context.Background()
+	expect := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(expect, NewMurmurHasher(), nil, WithReplicas(2))
+	if _, err := consistentHash.AddNode(ctx, "node_a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	expectVirtualNodes, _ := expect.VirtualNodes(ctx)
+
+	// 二进制格式的旧版本数据，虚拟节点表的 score 为 int32
+	var v1 struct {
+		FormatVersion int                 `json:"format_version"`
+		Config        RingConfig          `json:"config"`
+		Version       int64               `json:"version"`
+		Nodes         map[string]int      `json:"nodes"`
+		VirtualNodes  map[int32][]string  `json:"virtual_nodes"`
+		DataKeys      map[string][]string `json:"data_keys"`
+	}
+	if err := json.Unmarshal([]byte(ringDumpV1Fixture), &v1); err != nil {
+		t.Fatal(err)
+	}
+	var binary bytes.Buffer
+	binary.Write(ringDumpMagic)
+	if err := gob.NewEncoder(&binary).Encode(v1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, raw := range [][]byte{[]byte(ringDumpV1Fixture), binary.Bytes()} {
+		target := local.NewSkiplistHashRing()
+		imported := NewConsistentHash(target, NewMurmurHasher(), nil, WithReplicas(2))
+		if err := imported.Import(ctx, bytes.NewReader(raw)); err != nil {
+			t.Fatal(err)
+		}
+
+		virtualNodes, _ := target.VirtualNodes(ctx)
+		if !reflect.DeepEqual(expectVirtualNodes, virtualNodes) {
+			t.Fatalf("expect virtual nodes: %v, got: %v", expectVirtualNodes, virtualNodes)
+		}
+		if ok, _ := target.HasDataKey(ctx, "node_a", "data_0"); !ok {
+			t.Fatal("expect data key imported")
+		}
+	}
+
+	if _, err := ReadRingDump(bytes.NewReader([]byte(`{"format_version": 99}`))); err == nil {
+		t.Fatal("expect unsupported format version")
+	}
+}
@@ -5,15 +5,67 @@ import "context"
 type HashRing interface {
 	Lock(ctx context.Context, expireSeconds int) error
 	Unlock(ctx context.Context) error
-	Add(ctx context.Context, virtualScore int32, nodeID string) error
-	Ceiling(ctx context.Context, virtualScore int32) (int32, error)
-	Floor(ctx context.Context, virtualScore int32) (int32, error)
-	Rem(ctx context.Context, virtualScore int32, nodeID string) error
+	// 虚拟节点以 (nodeID, index) 的形式存储，index 为虚拟节点在所属节点中的下标
+	Add(ctx context.Context, virtualScore int64, nodeID string, index int) error
+	Ceiling(ctx context.Context, virtualScore int64) (int64, error)
+	Floor(ctx context.Context, virtualScore int64) (int64, error)
+	Rem(ctx context.Context, virtualScore int64, nodeID string, index int) error
 	Nodes(ctx context.Context) (map[string]int, error)
 	AddNodeToReplica(ctx context.Context, nodeID string, replicas int) error
 	DeleteNodeToReplica(ctx context.Context, nodeID string) error
-	Node(ctx context.Context, virtualScore int32) ([]string, error)
+	Node(ctx context.Context, virtualScore int64) ([]string, error)
+	// 节点的元数据，key 为 nodeID，val 为序列化后的元数据. 元数据的变更不影响哈希环的拓扑
+	NodeMetas(ctx context.Context) (map[string]string, error)
+	SetNodeMeta(ctx context.Context, nodeID, meta string) error
+	DeleteNodeMeta(ctx context.Context, nodeID string) error
+	// 节点的状态，只记录非 active 状态的节点. 节点状态只影响路由，不影响哈希环的拓扑
+	NodeStates(ctx context.Context) (map[string]string, error)
+	SetNodeState(ctx context.Context, nodeID, state string) error
+	DeleteNodeState(ctx context.Context, nodeID string) error
+	// 返回哈希环上全部的虚拟节点，key 为 virtualScore，val 为该 score 下每个节点的虚拟节点下标
+	VirtualNodes(ctx context.Context) (map[int64]map[string][]int, error)
+	// 哈希环的版本号，每次节点变更提交后递增，用于判断本地快照是否过期
+	Version(ctx context.Context) (int64, error)
+	IncrVersion(ctx context.Context) (int64, error)
+	// 哈希环的配置，记录所使用的 Encryptor 与放置策略. 尚未记录时返回空字符串
+	RingConfig(ctx context.Context) (string, error)
+	SetRingConfig(ctx context.Context, config string) error
+	// 数据 key 副本数的上限，节点变更时据此推算需要扫描的区间，尚未记录时返回 0.
+	// 上限只增不减，由记录数据 key 的读请求调用 RaiseDataKeyReplicas 维护，因此需要原子地更新，不依赖哈希环的锁
+	DataKeyReplicas(ctx context.Context) (int, error)
+	RaiseDataKeyReplicas(ctx context.Context, replicas int) error
+	// 异步迁移任务的状态，以字段的形式存储，便于不同进程分别更新不同的字段
+	SetMigrationJob(ctx context.Context, jobID string, fields map[string]string) error
+	MigrationJob(ctx context.Context, jobID string) (map[string]string, error)
+	// 迁移任务结束后设置过期时间（unix 毫秒），到期后任务的状态被删除
+	ExpireMigrationJob(ctx context.Context, jobID string, expireAt int64) error
+	// 发布与订阅哈希环的变更事件，事件以序列化后的字符串传递. ctx 结束后关闭订阅返回的 channel
+	Publish(ctx context.Context, event string) error
+	Subscribe(ctx context.Context) (<-chan string, error)
+}
+
+// 数据 key 与节点的映射关系，用于在节点变更时推算需要迁移的数据. 与哈希环的拓扑相互独立，
+// local 与 redis 中的哈希环同时实现了该接口，也可以通过 WithDataKeyIndex 单独指定
+type DataKeyIndex interface {
 	DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error)
+	DataKeysCount(ctx context.Context, nodeID string) (int, error)
+	HasDataKey(ctx context.Context, nodeID, dataKey string) (bool, error)
 	AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error
 	DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error
+	// 为节点下已经存在的数据 key 设置过期时间（unix 毫秒），0 代表永不过期. 过期的数据 key 不再出现在读取结果中
+	ExpireDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}, expireAt int64) error
+	// 返回数据 key 的过期时间，未设置过期时间或不存在的数据 key 不出现在结果中
+	DataKeysExpireAt(ctx context.Context, nodeID string, dataKeys map[string]struct{}) (map[string]int64, error)
+	// 分页遍历节点下的数据 key. cursor 传入空字符串代表从头开始，返回的 next 为空时代表遍历结束
+	ScanDataKeys(ctx context.Context, nodeID, cursor string, count int) ([]string, string, error)
+}
+
+// 按照 score 排序的数据 key 索引. 节点变更时只需要读取受影响区间内的数据 key，而不必分页遍历受影响节点下的全部数据 key
+type ScoredDataKeyIndex interface {
+	DataKeyIndex
+	// 写入数据 key 及其 score，已经存在的数据 key 更新 score
+	AddNodeToScoredDataKeys(ctx context.Context, nodeID string, dataKeys map[string]int64) error
+	// 返回 score 位于 (start, end] 之间的数据 key，结果可以多于区间内的数据 key，但不能遗漏.
+	// 通过 AddNodeToDataKeys 写入、未记录 score 的数据 key 总是包含在结果中
+	RangeDataKeys(ctx context.Context, nodeID string, start, end int64) (map[string]struct{}, error)
 }
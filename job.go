@@ -0,0 +1,279 @@
+package consistent_hash
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+type MigrationJobState string
+
+const (
+	MigrationJobPending   MigrationJobState = "pending"
+	MigrationJobRunning   MigrationJobState = "running"
+	MigrationJobSucceeded MigrationJobState = "succeeded"
+	MigrationJobFailed    MigrationJobState = "failed"
+	MigrationJobCanceled  MigrationJobState = "canceled"
+)
+
+// 迁移任务是否已经结束
+func (m MigrationJobState) Finished() bool {
+	return m == MigrationJobSucceeded || m == MigrationJobFailed || m == MigrationJobCanceled
+}
+
+// 迁移任务在哈希环中存储的字段
+const (
+	jobFieldState       = "state"
+	jobFieldTasksTotal  = "tasks_total"
+	jobFieldTasksDone   = "tasks_done"
+	jobFieldTasksFailed = "tasks_failed"
+	jobFieldKeysTotal   = "keys_total"
+	jobFieldKeysMoved   = "keys_moved"
+	jobFieldErr         = "err"
+	jobFieldCancel      = "cancel"
+	jobFieldCreatedAt   = "created_at"
+	jobFieldUpdatedAt   = "updated_at"
+)
+
+// 异步迁移任务的进度
+type MigrationJobStatus struct {
+	JobID       string
+	State       MigrationJobState
+	TasksTotal  int
+	TasksDone   int
+	TasksFailed int
+	KeysTotal   int
+	KeysMoved   int
+	// 迁移失败时的错误信息
+	Err string
+	// 是否有调用方请求取消迁移
+	CancelRequested bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// 当前进程内正在执行的迁移任务
+type migrationJob struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// 执行节点变更产生的迁移任务. 同步模式下执行完全部任务后返回迁移报告；
+// 异步模式下将迁移任务持久化到哈希环中，立即返回迁移任务 id
+func (c *ConsistentHash) executeMigration(ctx context.Context, version int64, migrateTasks []*MigrationTask) (*MigrationReport, error) {
+	if !c.opts.asyncMigration {
+		report := c.batchExecuteMigrator(ctx, migrateTasks, nil)
+		return report, report.Err()
+	}
+
+	jobID := newMigrationJobID(version)
+	// 迁移任务在后台执行，返回给调用方的是任务的副本，避免并发读写
+	report := MigrationReport{JobID: jobID}
+	var keysTotal int
+	for _, migrateTask := range migrateTasks {
+		keysTotal += migrateTask.KeyCount
+		report.Tasks = append(report.Tasks, &MigrationTask{
+			From:     migrateTask.From,
+			To:       migrateTask.To,
+			DataKeys: migrateTask.DataKeys,
+			KeyCount: migrateTask.KeyCount,
+			Ranges:   migrateTask.Ranges,
+		})
+	}
+
+	now := gocast.ToString(time.Now().UnixMilli())
+	if err := c.hashRing.SetMigrationJob(ctx, jobID, map[string]string{
+		jobFieldState:       string(MigrationJobPending),
+		jobFieldTasksTotal:  gocast.ToString(len(migrateTasks)),
+		jobFieldTasksDone:   "0",
+		jobFieldTasksFailed: "0",
+		jobFieldKeysTotal:   gocast.ToString(keysTotal),
+		jobFieldKeysMoved:   "0",
+		jobFieldCreatedAt:   now,
+		jobFieldUpdatedAt:   now,
+	}); err != nil {
+		// 哈希环的变更已经提交，返回尚未执行的迁移任务，由调用方决定如何处理
+		report.JobID = ""
+		return &report, fmt.Errorf("%w, create migration job failed, err: %v", ErrMigrationFailed, err)
+	}
+
+	// 迁移任务的生命周期独立于本次请求，不随 ctx 的结束而取消
+	jobCtx, cancel := context.WithCancel(context.Background())
+	job := migrationJob{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	c.jobs.Store(jobID, &job)
+	go c.runMigrationJob(jobCtx, jobID, &job, migrateTasks)
+
+	return &report, nil
+}
+
+// 迁移任务 id 由哈希环变更后的版本号与随机后缀组成. 变更回滚后版本号可能被重复使用，随机后缀保证 id 不会重复
+func newMigrationJobID(version int64) string {
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%d-%s", version, hex.EncodeToString(suffix))
+}
+
+func (c *ConsistentHash) runMigrationJob(ctx context.Context, jobID string, job *migrationJob, migrateTasks []*MigrationTask) {
+	defer func() {
+		job.cancel()
+		c.jobs.Delete(jobID)
+		close(job.done)
+	}()
+
+	// 进度的持久化是尽力而为的，失败不影响迁移本身
+	_ = c.saveMigrationJob(ctx, jobID, map[string]string{
+		jobFieldState: string(MigrationJobRunning),
+	})
+
+	// 其他进程可能通过哈希环请求取消迁移，需要定期检查
+	go c.watchMigrationJobCancel(ctx, jobID, job)
+
+	var (
+		mutex                             sync.Mutex
+		tasksDone, tasksFailed, keysMoved int
+	)
+	report := c.batchExecuteMigrator(ctx, migrateTasks, func(migrateTask *MigrationTask) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		tasksDone++
+		if migrateTask.Err != nil {
+			tasksFailed++
+		} else {
+			keysMoved += migrateTask.KeyCount
+		}
+		_ = c.saveMigrationJob(ctx, jobID, map[string]string{
+			jobFieldTasksDone:   gocast.ToString(tasksDone),
+			jobFieldTasksFailed: gocast.ToString(tasksFailed),
+			jobFieldKeysMoved:   gocast.ToString(keysMoved),
+		})
+	})
+
+	fields := map[string]string{
+		jobFieldState: string(MigrationJobSucceeded),
+	}
+	if err := report.Err(); err != nil {
+		fields[jobFieldState] = string(MigrationJobFailed)
+		fields[jobFieldErr] = err.Error()
+	}
+	if ctx.Err() != nil {
+		fields[jobFieldState] = string(MigrationJobCanceled)
+	}
+	// 迁移任务已经结束，使用新的 ctx 保存最终状态，并设置过期时间，避免任务的状态在哈希环中无限堆积
+	_ = c.saveMigrationJob(context.Background(), jobID, fields)
+	_ = c.hashRing.ExpireMigrationJob(context.Background(), jobID, time.Now().Add(c.opts.migrationJobTTL).UnixMilli())
+}
+
+func (c *ConsistentHash) watchMigrationJobCancel(ctx context.Context, jobID string, job *migrationJob) {
+	ticker := time.NewTicker(c.opts.migrationJobPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		status, err := c.MigrationJob(ctx, jobID)
+		if err != nil {
+			continue
+		}
+		if status.CancelRequested {
+			job.cancel()
+			return
+		}
+	}
+}
+
+func (c *ConsistentHash) saveMigrationJob(ctx context.Context, jobID string, fields map[string]string) error {
+	fields[jobFieldUpdatedAt] = gocast.ToString(time.Now().UnixMilli())
+	return c.hashRing.SetMigrationJob(ctx, jobID, fields)
+}
+
+// 查询迁移任务的进度，迁移任务可以由其他进程创建
+func (c *ConsistentHash) MigrationJob(ctx context.Context, jobID string) (*MigrationJobStatus, error) {
+	fields, err := c.hashRing.MigrationJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("migration job: %s not exist", jobID)
+	}
+
+	return &MigrationJobStatus{
+		JobID:           jobID,
+		State:           MigrationJobState(fields[jobFieldState]),
+		TasksTotal:      gocast.ToInt(fields[jobFieldTasksTotal]),
+		TasksDone:       gocast.ToInt(fields[jobFieldTasksDone]),
+		TasksFailed:     gocast.ToInt(fields[jobFieldTasksFailed]),
+		KeysTotal:       gocast.ToInt(fields[jobFieldKeysTotal]),
+		KeysMoved:       gocast.ToInt(fields[jobFieldKeysMoved]),
+		Err:             fields[jobFieldErr],
+		CancelRequested: fields[jobFieldCancel] != "",
+		CreatedAt:       time.UnixMilli(gocast.ToInt64(fields[jobFieldCreatedAt])),
+		UpdatedAt:       time.UnixMilli(gocast.ToInt64(fields[jobFieldUpdatedAt])),
+	}, nil
+}
+
+// 阻塞等待迁移任务结束，返回最终的进度
+func (c *ConsistentHash) WaitMigrationJob(ctx context.Context, jobID string) (*MigrationJobStatus, error) {
+	// 本进程内执行的迁移任务，直接等待其结束
+	if job, ok := c.jobs.Load(jobID); ok {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-job.(*migrationJob).done:
+		}
+	}
+
+	// 其他进程执行的迁移任务，只能轮询哈希环中的状态
+	ticker := time.NewTicker(c.opts.migrationJobPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := c.MigrationJob(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if status.State.Finished() {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// 取消迁移任务. 正在执行的 Migrator 调用不会被中断，但不会再发起新的调用与重试
+func (c *ConsistentHash) CancelMigrationJob(ctx context.Context, jobID string) error {
+	status, err := c.MigrationJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	if status.State.Finished() {
+		return errors.New("migration job finished")
+	}
+
+	if err = c.saveMigrationJob(ctx, jobID, map[string]string{
+		jobFieldCancel: "1",
+	}); err != nil {
+		return err
+	}
+
+	if job, ok := c.jobs.Load(jobID); ok {
+		job.(*migrationJob).cancel()
+	}
+	return nil
+}
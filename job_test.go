@@ -0,0 +1,111 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func newAsyncConsistentHash(t *testing.T, migrator Migrator, opts ...ConsistentHashOption) *ConsistentHash {
+	ctx := context.Background()
+	opts = append(opts, WithAsyncMigration(), WithMigrationJobPollInterval(10*time.Millisecond))
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), migrator, opts...)
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return consistentHash
+}
+
+func Test_async_migration_job(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	consistentHash := newAsyncConsistentHash(t, func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		<-release
+		return nil
+	})
+
+	report, err := consistentHash.AddNode(ctx, "node_b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.JobID == "" || len(report.Tasks) == 0 {
+		t.Fatalf("expect migration job, got: %+v", report)
+	}
+
+	// 拓扑变更已经生效，迁移尚未完成
+	status, err := consistentHash.MigrationJob(ctx, report.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State.Finished() || status.TasksTotal != len(report.Tasks) || status.KeysTotal != report.KeyCount() {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	close(release)
+	if status, err = consistentHash.WaitMigrationJob(ctx, report.JobID); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != MigrationJobSucceeded || status.TasksDone != status.TasksTotal || status.KeysMoved != status.KeysTotal {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func Test_async_migration_job_cancel(t *testing.T) {
+	ctx := context.Background()
+	started := make(chan struct{}, 1)
+	consistentHash := newAsyncConsistentHash(t, func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithMigrateConcurrency(1))
+
+	report, err := consistentHash.AddNode(ctx, "node_b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+	if err = consistentHash.CancelMigrationJob(ctx, report.JobID); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := consistentHash.WaitMigrationJob(ctx, report.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != MigrationJobCanceled || !status.CancelRequested || status.KeysMoved != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func Test_async_migration_job_expire(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := newAsyncConsistentHash(t, func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithMigrationJobTTL(50*time.Millisecond))
+
+	report, err := consistentHash.AddNode(ctx, "node_b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = consistentHash.WaitMigrationJob(ctx, report.JobID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 结束的迁移任务到期后被删除
+	time.Sleep(100 * time.Millisecond)
+	if _, err = consistentHash.MigrationJob(ctx, report.JobID); err == nil {
+		t.Fatal("expect migration job expired")
+	}
+}
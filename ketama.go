@@ -0,0 +1,120 @@
+package consistent_hash
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 与 libketama 兼容的一致性哈希. 对于相同的服务端列表，路由结果与使用 ketama 的 memcached 客户端逐字节一致：
+// 每个节点按照权重占比分得 floor(pct * 40 * 节点个数) 个摘要，摘要由 md5("nodeID-i") 得到，每个摘要派生出 4 个点；
+// 数据 key 取 md5 摘要的前 4 个字节按小端序组成 32 位整数，落在首个 >= 该值的点上，越过环尾时回到首个点.
+// 节点的点位取决于全部节点的权重，因此不写入虚拟节点，而是随快照一同重建
+type ketamaPlacement struct {
+	c *ConsistentHash
+}
+
+func newKetamaPlacement(c *ConsistentHash) placement {
+	return &ketamaPlacement{c: c}
+}
+
+// ketama 环上的一个点，node 为物理节点在 members 中的下标
+type ketamaPoint struct {
+	point uint32
+	node  int
+}
+
+func (k *ketamaPlacement) name() string {
+	return "ketama"
+}
+
+func (k *ketamaPlacement) join(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return nil, nil
+}
+
+func (k *ketamaPlacement) leave(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return nil, nil
+}
+
+// 权重取自 HashRing 中记录的虚拟节点个数，点位会随快照一同重建
+func (k *ketamaPlacement) resize(snapshot *ringSnapshot, nodeID string, oldReplicas, newReplicas int) ([]ringVirtualNode, []ringVirtualNode, error) {
+	return nil, nil, nil
+}
+
+// 与 libketama 的 ketama_create_continuum 保持一致，包括单精度浮点数的计算过程
+func (k *ketamaPlacement) build(snapshot *ringSnapshot) {
+	var total int
+	for _, nodeID := range snapshot.members {
+		total += snapshot.weights[nodeID]
+	}
+	if total == 0 {
+		return
+	}
+
+	numServers := float32(len(snapshot.members))
+	continuum := make([]ketamaPoint, 0, 160*len(snapshot.members))
+	for i, nodeID := range snapshot.members {
+		pct := float32(snapshot.weights[nodeID]) / float32(total)
+		ks := int(math.Floor(float64(float32(float64(pct) * 40.0 * float64(numServers)))))
+		for j := 0; j < ks; j++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", nodeID, j)))
+			for h := 0; h < 4; h++ {
+				continuum = append(continuum, ketamaPoint{
+					point: binary.LittleEndian.Uint32(digest[h*4 : h*4+4]),
+					node:  i,
+				})
+			}
+		}
+	}
+
+	// 点位相同时按照节点 id 排序，保证结果是确定的
+	sort.Slice(continuum, func(i, j int) bool {
+		if continuum[i].point != continuum[j].point {
+			return continuum[i].point < continuum[j].point
+		}
+		return continuum[i].node < continuum[j].node
+	})
+	snapshot.continuum = continuum
+}
+
+// 每个点拥有 (前一个点, 当前点] 之间的弧，首个点的弧跨越环尾
+func (k *ketamaPlacement) ownership(snapshot *ringSnapshot) map[string]float64 {
+	ownership := make(map[string]float64, len(snapshot.members))
+	for i, point := range snapshot.continuum {
+		prev := snapshot.continuum[(i+len(snapshot.continuum)-1)%len(snapshot.continuum)].point
+		// uint32 的减法天然处理了环尾的回绕
+		ownership[snapshot.members[point.node]] += float64(point.point-prev) / (math.MaxUint32 + 1)
+	}
+	if len(snapshot.continuum) == 1 {
+		ownership[snapshot.members[snapshot.continuum[0].node]] = 1
+	}
+	return ownership
+}
+
+// 数据 key 的哈希值与 libketama 的 ketama_hashi 一致，不使用 Encryptor 计算得到的 dataScore
+func (k *ketamaPlacement) locate(snapshot *ringSnapshot, dataKey string, dataScore int64, n int) []string {
+	if len(snapshot.continuum) == 0 {
+		return nil
+	}
+
+	if n > snapshot.nodeCount {
+		n = snapshot.nodeCount
+	}
+
+	digest := md5.Sum([]byte(dataKey))
+	hash := binary.LittleEndian.Uint32(digest[:4])
+	start := sort.Search(len(snapshot.continuum), func(i int) bool {
+		return snapshot.continuum[i].point >= hash
+	})
+
+	nodeIDs := make([]string, 0, n)
+	for i := 0; i < len(snapshot.continuum) && len(nodeIDs) < n; i++ {
+		nodeID := snapshot.members[snapshot.continuum[(start+i)%len(snapshot.continuum)].node]
+		if !contains(nodeIDs, nodeID) {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+	return nodeIDs
+}
@@ -0,0 +1,196 @@
+package local
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 未记录 score 的数据 key 在跳表中使用的 score，范围扫描时总是包含这些数据 key
+const unscored = math.MinInt64
+
+// 基于本地内存的数据 key 索引
+type DataKeyIndex struct {
+	// 每个节点下的数据 key 及其过期时间（unix 毫秒），0 代表永不过期
+	nodeToDataKey map[string]map[string]int64
+	// 每个节点下按照 score 排序的数据 key
+	nodeToScores map[string]*scoreList
+	// GetNode 不持有哈希环的锁，因此数据 key 的读写需要单独的锁保护
+	mutex sync.RWMutex
+}
+
+func NewDataKeyIndex() *DataKeyIndex {
+	return &DataKeyIndex{
+		nodeToDataKey: make(map[string]map[string]int64),
+		nodeToScores:  make(map[string]*scoreList),
+	}
+}
+
+func expired(expireAt, now int64) bool {
+	return expireAt > 0 && expireAt <= now
+}
+
+// 清理节点下已经过期的数据 key，需要持有写锁
+func (d *DataKeyIndex) purge(nodeID string, now int64) {
+	dataKeys := d.nodeToDataKey[nodeID]
+	for dataKey, expireAt := range dataKeys {
+		if expired(expireAt, now) {
+			delete(dataKeys, dataKey)
+			d.nodeToScores[nodeID].remove(dataKey)
+		}
+	}
+	if len(dataKeys) == 0 {
+		delete(d.nodeToDataKey, nodeID)
+		delete(d.nodeToScores, nodeID)
+	}
+}
+
+func (d *DataKeyIndex) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.purge(nodeID, time.Now().UnixMilli())
+	// 返回副本，避免调用方遍历时与并发写入冲突
+	dataKeys := make(map[string]struct{}, len(d.nodeToDataKey[nodeID]))
+	for dataKey := range d.nodeToDataKey[nodeID] {
+		dataKeys[dataKey] = struct{}{}
+	}
+	return dataKeys, nil
+}
+
+func (d *DataKeyIndex) DataKeysCount(ctx context.Context, nodeID string) (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.purge(nodeID, time.Now().UnixMilli())
+	return len(d.nodeToDataKey[nodeID]), nil
+}
+
+func (d *DataKeyIndex) HasDataKey(ctx context.Context, nodeID, dataKey string) (bool, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	expireAt, ok := d.nodeToDataKey[nodeID][dataKey]
+	return ok && !expired(expireAt, time.Now().UnixMilli()), nil
+}
+
+// 写入数据 key，已经存在的数据 key 保留原有的过期时间与 score
+func (d *DataKeyIndex) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	oldDataKeys, scores := d.nodeDataKeys(nodeID)
+	for _dataKey := range dataKeys {
+		if _, ok := oldDataKeys[_dataKey]; !ok {
+			oldDataKeys[_dataKey] = 0
+			scores.add(_dataKey, unscored)
+		}
+	}
+	return nil
+}
+
+// 写入数据 key 及其 score，已经存在的数据 key 保留原有的过期时间
+func (d *DataKeyIndex) AddNodeToScoredDataKeys(ctx context.Context, nodeID string, dataKeys map[string]int64) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	oldDataKeys, scores := d.nodeDataKeys(nodeID)
+	for dataKey, score := range dataKeys {
+		if _, ok := oldDataKeys[dataKey]; !ok {
+			oldDataKeys[dataKey] = 0
+		}
+		scores.add(dataKey, score)
+	}
+	return nil
+}
+
+// 获取节点下的数据 key 与跳表，不存在时创建，需要持有写锁
+func (d *DataKeyIndex) nodeDataKeys(nodeID string) (map[string]int64, *scoreList) {
+	dataKeys, ok := d.nodeToDataKey[nodeID]
+	if !ok {
+		dataKeys = make(map[string]int64)
+		d.nodeToDataKey[nodeID] = dataKeys
+		d.nodeToScores[nodeID] = newScoreList()
+	}
+	return dataKeys, d.nodeToScores[nodeID]
+}
+
+func (d *DataKeyIndex) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	oldDataKeys := d.nodeToDataKey[nodeID]
+	if oldDataKeys == nil {
+		return nil
+	}
+	for dataKey := range dataKeys {
+		delete(oldDataKeys, dataKey)
+		d.nodeToScores[nodeID].remove(dataKey)
+	}
+	if len(oldDataKeys) == 0 {
+		delete(d.nodeToDataKey, nodeID)
+		delete(d.nodeToScores, nodeID)
+	}
+	return nil
+}
+
+// 返回 score 位于 (start, end] 之间的数据 key，未记录 score 的数据 key 总是包含在结果中
+func (d *DataKeyIndex) RangeDataKeys(ctx context.Context, nodeID string, start, end int64) (map[string]struct{}, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	dataKeys := make(map[string]struct{})
+	scores, ok := d.nodeToScores[nodeID]
+	if !ok {
+		return dataKeys, nil
+	}
+
+	now := time.Now().UnixMilli()
+	collect := func(dataKey string) {
+		if !expired(d.nodeToDataKey[nodeID][dataKey], now) {
+			dataKeys[dataKey] = struct{}{}
+		}
+	}
+	scores.rangeAt(unscored, collect)
+	scores.rangeByScore(start, end, collect)
+	return dataKeys, nil
+}
+
+func (d *DataKeyIndex) ExpireDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}, expireAt int64) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	oldDataKeys := d.nodeToDataKey[nodeID]
+	for dataKey := range dataKeys {
+		if _, ok := oldDataKeys[dataKey]; ok {
+			oldDataKeys[dataKey] = expireAt
+		}
+	}
+	return nil
+}
+
+func (d *DataKeyIndex) DataKeysExpireAt(ctx context.Context, nodeID string, dataKeys map[string]struct{}) (map[string]int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	expireAts := make(map[string]int64)
+	for dataKey := range dataKeys {
+		if expireAt := d.nodeToDataKey[nodeID][dataKey]; expireAt > 0 {
+			expireAts[dataKey] = expireAt
+		}
+	}
+	return expireAts, nil
+}
+
+// 按照字典序分页遍历节点下的数据 key. cursor 为上一页最后一个数据 key，返回的 next 为空时代表遍历结束
+func (d *DataKeyIndex) ScanDataKeys(ctx context.Context, nodeID, cursor string, count int) ([]string, string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	now := time.Now().UnixMilli()
+	dataKeys := make([]string, 0, len(d.nodeToDataKey[nodeID]))
+	for dataKey, expireAt := range d.nodeToDataKey[nodeID] {
+		if dataKey > cursor && !expired(expireAt, now) {
+			dataKeys = append(dataKeys, dataKey)
+		}
+	}
+	sort.Strings(dataKeys)
+
+	if len(dataKeys) <= count {
+		return dataKeys, "", nil
+	}
+	dataKeys = dataKeys[:count]
+	return dataKeys, dataKeys[count-1], nil
+}
@@ -57,10 +57,30 @@ func (l *LockEntityV2) Unlock(ctx context.Context) error {
 // 基于本地跳表实现一个 hash_ring
 type SkiplistHashRing struct {
 	LockEntity
-	root *virtualNode
+	// 保护跳表、节点信息与哈希环配置. 快照的重建不获取哈希环的锁，读写需要在此互斥
+	ringMutex sync.RWMutex
+	root      *virtualNode
 	// 每个节点对应的虚拟节点个数
 	nodeToReplicas map[string]int
-	nodeToDataKey  map[string]map[string]struct{}
+	// 每个节点序列化后的元数据
+	nodeToMeta map[string]string
+	// 处于非 active 状态的节点
+	nodeToState map[string]string
+	// 哈希环同时实现了数据 key 的索引
+	*DataKeyIndex
+	version int64
+	// 数据 key 副本数的上限
+	dataKeyReplicas int64
+	// 序列化后的哈希环配置
+	ringConfig string
+	// 异步迁移任务的状态，由后台执行迁移的 goroutine 更新，需要单独的锁保护
+	migrationJobs map[string]map[string]string
+	// 迁移任务的过期时间（unix 毫秒）
+	migrationJobExpireAts map[string]int64
+	jobMutex              sync.RWMutex
+	// 哈希环变更事件的订阅者
+	subscribers     map[chan string]struct{}
+	subscriberMutex sync.RWMutex
 }
 
 type LockEntity struct {
@@ -73,19 +93,29 @@ type LockEntity struct {
 
 func NewSkiplistHashRing() *SkiplistHashRing {
 	return &SkiplistHashRing{
-		root:           &virtualNode{},
-		nodeToReplicas: make(map[string]int),
-		nodeToDataKey:  make(map[string]map[string]struct{}),
+		root:                  &virtualNode{},
+		nodeToReplicas:        make(map[string]int),
+		nodeToMeta:            make(map[string]string),
+		nodeToState:           make(map[string]string),
+		DataKeyIndex:          NewDataKeyIndex(),
+		migrationJobs:         make(map[string]map[string]string),
+		migrationJobExpireAts: make(map[string]int64),
+		subscribers:           make(map[chan string]struct{}),
 	}
 }
 
 type virtualNode struct {
-	score int32
-	// 存储的 nodeID 列表
-	nodeIDs []string
+	score int64
+	// score 下的虚拟节点，以 (nodeID, index) 的形式存储
+	entries []virtualNodeEntry
 	nexts   []*virtualNode
 }
 
+type virtualNodeEntry struct {
+	nodeID string
+	index  int
+}
+
 // 锁住哈希环，支持配置过期时间. 达到过期时间后，会自动释放锁
 func (s *SkiplistHashRing) Lock(ctx context.Context, expireSeconds int) error {
 	// 只锁定指定的时长
@@ -139,15 +169,18 @@ func (s *SkiplistHashRing) Unlock(ctx context.Context) error {
 	return s.unlock(ctx, token)
 }
 
-func (s *SkiplistHashRing) Add(ctx context.Context, score int32, nodeID string) error {
+func (s *SkiplistHashRing) Add(ctx context.Context, score int64, nodeID string, index int) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+	entry := virtualNodeEntry{nodeID: nodeID, index: index}
 	targetNode, ok := s.get(score)
 	if ok {
-		for _, _nodeID := range targetNode.nodeIDs {
-			if _nodeID == nodeID {
+		for _, _entry := range targetNode.entries {
+			if _entry == entry {
 				return nil
 			}
 		}
-		targetNode.nodeIDs = append(targetNode.nodeIDs, nodeID)
+		targetNode.entries = append(targetNode.entries, entry)
 		return nil
 	}
 
@@ -160,7 +193,7 @@ func (s *SkiplistHashRing) Add(ctx context.Context, score int32, nodeID string)
 	newNode := virtualNode{
 		score:   score,
 		nexts:   make([]*virtualNode, rLevel+1),
-		nodeIDs: []string{nodeID},
+		entries: []virtualNodeEntry{entry},
 	}
 
 	// 层数从高到低
@@ -176,7 +209,9 @@ func (s *SkiplistHashRing) Add(ctx context.Context, score int32, nodeID string)
 	return nil
 }
 
-func (s *SkiplistHashRing) Ceiling(ctx context.Context, score int32) (int32, error) {
+func (s *SkiplistHashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
 	target, ok := s.ceiling(score)
 	if ok {
 		return target, nil
@@ -186,7 +221,9 @@ func (s *SkiplistHashRing) Ceiling(ctx context.Context, score int32) (int32, err
 	return first, nil
 }
 
-func (s *SkiplistHashRing) Floor(ctx context.Context, score int32) (int32, error) {
+func (s *SkiplistHashRing) Floor(ctx context.Context, score int64) (int64, error) {
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
 	target, ok := s.floor(score)
 	if ok {
 		return target, nil
@@ -196,28 +233,28 @@ func (s *SkiplistHashRing) Floor(ctx context.Context, score int32) (int32, error
 	return last, nil
 }
 
-func (s *SkiplistHashRing) Rem(ctx context.Context, score int32, nodeID string) error {
+func (s *SkiplistHashRing) Rem(ctx context.Context, score int64, nodeID string, index int) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
 	targetNode, ok := s.get(score)
 	if !ok {
 		return fmt.Errorf("score: %d not exist", score)
 	}
 
-	index := -1
-	for i := 0; i < len(targetNode.nodeIDs); i++ {
-		if targetNode.nodeIDs[i] == nodeID {
-			index = i
+	pos := -1
+	for i := 0; i < len(targetNode.entries); i++ {
+		if targetNode.entries[i] == (virtualNodeEntry{nodeID: nodeID, index: index}) {
+			pos = i
 			break
 		}
 	}
 
-	if index == -1 {
-		return fmt.Errorf("node: %s not exist in score: %d", nodeID, score)
+	if pos == -1 {
+		return fmt.Errorf("node: %s, index: %d not exist in score: %d", nodeID, index, score)
 	}
 
-	delete(s.nodeToDataKey, nodeID)
-
-	if len(targetNode.nodeIDs) > 1 {
-		targetNode.nodeIDs = append(targetNode.nodeIDs[:index], targetNode.nodeIDs[index+1:]...)
+	if len(targetNode.entries) > 1 {
+		targetNode.entries = append(targetNode.entries[:pos], targetNode.entries[pos+1:]...)
 		return nil
 	}
 
@@ -245,57 +282,223 @@ func (s *SkiplistHashRing) Rem(ctx context.Context, score int32, nodeID string)
 }
 
 func (s *SkiplistHashRing) Nodes(ctx context.Context) (map[string]int, error) {
-	return s.nodeToReplicas, nil
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
+	nodes := make(map[string]int, len(s.nodeToReplicas))
+	for nodeID, replicas := range s.nodeToReplicas {
+		nodes[nodeID] = replicas
+	}
+	return nodes, nil
 }
 
 func (s *SkiplistHashRing) AddNodeToReplica(ctx context.Context, nodeID string, replicas int) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
 	s.nodeToReplicas[nodeID] = replicas
 	return nil
 }
 
 func (s *SkiplistHashRing) DeleteNodeToReplica(ctx context.Context, nodeID string) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
 	delete(s.nodeToReplicas, nodeID)
 	return nil
 }
 
-func (s *SkiplistHashRing) Node(ctx context.Context, score int32) ([]string, error) {
+func (s *SkiplistHashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
 	targetNode, ok := s.get(score)
 	if !ok {
 		return nil, fmt.Errorf("score: %d not exist", score)
 	}
-	return targetNode.nodeIDs, nil
+
+	// 同一个节点的多个虚拟节点可能碰撞到同一个 score 上，只返回一次
+	nodeIDs := make([]string, 0, len(targetNode.entries))
+	ranged := make(map[string]struct{}, len(targetNode.entries))
+	for _, entry := range targetNode.entries {
+		if _, ok := ranged[entry.nodeID]; ok {
+			continue
+		}
+		ranged[entry.nodeID] = struct{}{}
+		nodeIDs = append(nodeIDs, entry.nodeID)
+	}
+	return nodeIDs, nil
+}
+
+func (s *SkiplistHashRing) NodeMetas(ctx context.Context) (map[string]string, error) {
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
+	metas := make(map[string]string, len(s.nodeToMeta))
+	for nodeID, meta := range s.nodeToMeta {
+		metas[nodeID] = meta
+	}
+	return metas, nil
+}
+
+func (s *SkiplistHashRing) SetNodeMeta(ctx context.Context, nodeID, meta string) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+	s.nodeToMeta[nodeID] = meta
+	return nil
+}
+
+func (s *SkiplistHashRing) DeleteNodeMeta(ctx context.Context, nodeID string) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+	delete(s.nodeToMeta, nodeID)
+	return nil
+}
+
+func (s *SkiplistHashRing) NodeStates(ctx context.Context) (map[string]string, error) {
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
+	states := make(map[string]string, len(s.nodeToState))
+	for nodeID, state := range s.nodeToState {
+		states[nodeID] = state
+	}
+	return states, nil
+}
+
+func (s *SkiplistHashRing) SetNodeState(ctx context.Context, nodeID, state string) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+	s.nodeToState[nodeID] = state
+	return nil
+}
+
+func (s *SkiplistHashRing) DeleteNodeState(ctx context.Context, nodeID string) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+	delete(s.nodeToState, nodeID)
+	return nil
+}
+
+func (s *SkiplistHashRing) VirtualNodes(ctx context.Context) (map[int64]map[string][]int, error) {
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
+	virtualNodes := make(map[int64]map[string][]int)
+	if len(s.root.nexts) == 0 {
+		return virtualNodes, nil
+	}
+
+	for move := s.root.nexts[0]; move != nil; move = move.nexts[0] {
+		nodes := make(map[string][]int, len(move.entries))
+		for _, entry := range move.entries {
+			nodes[entry.nodeID] = append(nodes[entry.nodeID], entry.index)
+		}
+		virtualNodes[move.score] = nodes
+	}
+	return virtualNodes, nil
+}
+
+func (s *SkiplistHashRing) Version(ctx context.Context) (int64, error) {
+	return atomic.LoadInt64(&s.version), nil
+}
+
+func (s *SkiplistHashRing) IncrVersion(ctx context.Context) (int64, error) {
+	return atomic.AddInt64(&s.version, 1), nil
+}
+
+func (s *SkiplistHashRing) RingConfig(ctx context.Context) (string, error) {
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
+	return s.ringConfig, nil
 }
 
-func (s *SkiplistHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
-	return s.nodeToDataKey[nodeID], nil
+func (s *SkiplistHashRing) SetRingConfig(ctx context.Context, config string) error {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+	s.ringConfig = config
+	return nil
+}
+
+func (s *SkiplistHashRing) DataKeyReplicas(ctx context.Context) (int, error) {
+	return int(atomic.LoadInt64(&s.dataKeyReplicas)), nil
+}
+
+func (s *SkiplistHashRing) RaiseDataKeyReplicas(ctx context.Context, replicas int) error {
+	for {
+		current := atomic.LoadInt64(&s.dataKeyReplicas)
+		if int64(replicas) <= current || atomic.CompareAndSwapInt64(&s.dataKeyReplicas, current, int64(replicas)) {
+			return nil
+		}
+	}
 }
 
-func (s *SkiplistHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
-	oldDataKeys := s.nodeToDataKey[nodeID]
-	if oldDataKeys == nil {
-		oldDataKeys = make(map[string]struct{})
+func (s *SkiplistHashRing) SetMigrationJob(ctx context.Context, jobID string, fields map[string]string) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	job := s.migrationJobs[jobID]
+	if job == nil {
+		job = make(map[string]string, len(fields))
+		s.migrationJobs[jobID] = job
 	}
-	for _dataKey := range dataKeys {
-		oldDataKeys[_dataKey] = struct{}{}
+	for field, val := range fields {
+		job[field] = val
 	}
-	s.nodeToDataKey[nodeID] = oldDataKeys
 	return nil
 }
 
-func (s *SkiplistHashRing) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
-	oldDataKeys := s.nodeToDataKey[nodeID]
-	if oldDataKeys == nil {
-		return nil
+func (s *SkiplistHashRing) MigrationJob(ctx context.Context, jobID string) (map[string]string, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	if expireAt, ok := s.migrationJobExpireAts[jobID]; ok && expireAt <= time.Now().UnixMilli() {
+		return map[string]string{}, nil
 	}
-	for dataKey := range dataKeys {
-		delete(oldDataKeys, dataKey)
+	fields := make(map[string]string, len(s.migrationJobs[jobID]))
+	for field, val := range s.migrationJobs[jobID] {
+		fields[field] = val
 	}
-	if len(oldDataKeys) == 0 {
-		delete(s.nodeToDataKey, nodeID)
+	return fields, nil
+}
+
+// 设置过期时间的同时，清理已经过期的迁移任务
+func (s *SkiplistHashRing) ExpireMigrationJob(ctx context.Context, jobID string, expireAt int64) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	now := time.Now().UnixMilli()
+	for _jobID, _expireAt := range s.migrationJobExpireAts {
+		if _expireAt <= now {
+			delete(s.migrationJobs, _jobID)
+			delete(s.migrationJobExpireAts, _jobID)
+		}
+	}
+	if _, ok := s.migrationJobs[jobID]; ok {
+		s.migrationJobExpireAts[jobID] = expireAt
 	}
 	return nil
 }
 
+// 将事件分发给进程内的全部订阅者. 订阅者消费过慢、缓冲区已满时丢弃事件，避免阻塞哈希环的变更
+func (s *SkiplistHashRing) Publish(ctx context.Context, event string) error {
+	s.subscriberMutex.RLock()
+	defer s.subscriberMutex.RUnlock()
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *SkiplistHashRing) Subscribe(ctx context.Context) (<-chan string, error) {
+	subscriber := make(chan string, 64)
+	s.subscriberMutex.Lock()
+	s.subscribers[subscriber] = struct{}{}
+	s.subscriberMutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.subscriberMutex.Lock()
+		defer s.subscriberMutex.Unlock()
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}()
+	return subscriber, nil
+}
+
 func (s *SkiplistHashRing) roll() int {
 	rander := rand.New(rand.NewSource(time.Now().UnixNano()))
 	var level int
@@ -306,7 +509,7 @@ func (s *SkiplistHashRing) roll() int {
 }
 
 // 获得 >= score 且最接近 score 的目标
-func (s *SkiplistHashRing) ceiling(score int32) (int32, bool) {
+func (s *SkiplistHashRing) ceiling(score int64) (int64, bool) {
 	if len(s.root.nexts) == 0 {
 		return -1, false
 	}
@@ -325,7 +528,7 @@ func (s *SkiplistHashRing) ceiling(score int32) (int32, bool) {
 	return move.nexts[0].score, true
 }
 
-func (s *SkiplistHashRing) first() (int32, bool) {
+func (s *SkiplistHashRing) first() (int64, bool) {
 	if len(s.root.nexts) == 0 {
 		return -1, false
 	}
@@ -333,7 +536,7 @@ func (s *SkiplistHashRing) first() (int32, bool) {
 	return s.root.nexts[0].score, true
 }
 
-func (s *SkiplistHashRing) floor(score int32) (int32, bool) {
+func (s *SkiplistHashRing) floor(score int64) (int64, bool) {
 	if len(s.root.nexts) == 0 {
 		return -1, false
 	}
@@ -357,7 +560,7 @@ func (s *SkiplistHashRing) floor(score int32) (int32, bool) {
 }
 
 // 返回最大的节点
-func (s *SkiplistHashRing) last() (int32, bool) {
+func (s *SkiplistHashRing) last() (int64, bool) {
 	// 层数从高到低
 	move := s.root
 	for level := len(s.root.nexts) - 1; level >= 0; level-- {
@@ -373,7 +576,7 @@ func (s *SkiplistHashRing) last() (int32, bool) {
 	return move.score, true
 }
 
-func (s *SkiplistHashRing) get(score int32) (*virtualNode, bool) {
+func (s *SkiplistHashRing) get(score int64) (*virtualNode, bool) {
 	move := s.root
 	for level := len(s.root.nexts) - 1; level >= 0; level-- {
 		for move.nexts[level] != nil && move.nexts[level].score < score {
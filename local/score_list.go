@@ -0,0 +1,139 @@
+package local
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 跳表的最大层数
+const scoreListMaxLevel = 32
+
+// 按照 (score, dataKey) 升序排列的跳表，用于按照 score 区间扫描数据 key
+type scoreList struct {
+	root *scoreNode
+	// 每个数据 key 对应的 score
+	scores map[string]int64
+	rander *rand.Rand
+}
+
+type scoreNode struct {
+	score   int64
+	dataKey string
+	nexts   []*scoreNode
+}
+
+func newScoreList() *scoreList {
+	return &scoreList{
+		root:   &scoreNode{},
+		scores: make(map[string]int64),
+		rander: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// node 是否排在 (score, dataKey) 之前
+func (n *scoreNode) less(score int64, dataKey string) bool {
+	return n.score < score || (n.score == score && n.dataKey < dataKey)
+}
+
+func (l *scoreList) len() int {
+	return len(l.scores)
+}
+
+// 写入数据 key，已经存在的数据 key 会更新 score
+func (l *scoreList) add(dataKey string, score int64) {
+	if oldScore, ok := l.scores[dataKey]; ok {
+		if oldScore == score {
+			return
+		}
+		l.remove(dataKey)
+	}
+	l.scores[dataKey] = score
+
+	level := l.roll()
+	if len(l.root.nexts) < level+1 {
+		difs := make([]*scoreNode, level+1-len(l.root.nexts))
+		l.root.nexts = append(l.root.nexts, difs...)
+	}
+
+	newNode := scoreNode{
+		score:   score,
+		dataKey: dataKey,
+		nexts:   make([]*scoreNode, level+1),
+	}
+
+	move := l.root
+	for i := level; i >= 0; i-- {
+		for move.nexts[i] != nil && move.nexts[i].less(score, dataKey) {
+			move = move.nexts[i]
+		}
+		newNode.nexts[i] = move.nexts[i]
+		move.nexts[i] = &newNode
+	}
+}
+
+func (l *scoreList) remove(dataKey string) {
+	score, ok := l.scores[dataKey]
+	if !ok {
+		return
+	}
+	delete(l.scores, dataKey)
+
+	move := l.root
+	for i := len(l.root.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && move.nexts[i].less(score, dataKey) {
+			move = move.nexts[i]
+		}
+		if next := move.nexts[i]; next != nil && next.score == score && next.dataKey == dataKey {
+			move.nexts[i] = next.nexts[i]
+		}
+	}
+
+	// 收缩空的层
+	for len(l.root.nexts) > 0 && l.root.nexts[len(l.root.nexts)-1] == nil {
+		l.root.nexts = l.root.nexts[:len(l.root.nexts)-1]
+	}
+}
+
+// 依次遍历 score 位于 (start, end] 之间的数据 key
+func (l *scoreList) rangeByScore(start, end int64, fn func(dataKey string)) {
+	if len(l.root.nexts) == 0 {
+		return
+	}
+
+	move := l.root
+	for i := len(l.root.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && move.nexts[i].score <= start {
+			move = move.nexts[i]
+		}
+	}
+
+	for node := move.nexts[0]; node != nil && node.score <= end; node = node.nexts[0] {
+		fn(node.dataKey)
+	}
+}
+
+// 依次遍历 score 恰好为 score 的数据 key
+func (l *scoreList) rangeAt(score int64, fn func(dataKey string)) {
+	if len(l.root.nexts) == 0 {
+		return
+	}
+
+	move := l.root
+	for i := len(l.root.nexts) - 1; i >= 0; i-- {
+		for move.nexts[i] != nil && move.nexts[i].score < score {
+			move = move.nexts[i]
+		}
+	}
+
+	for node := move.nexts[0]; node != nil && node.score == score; node = node.nexts[0] {
+		fn(node.dataKey)
+	}
+}
+
+func (l *scoreList) roll() int {
+	var level int
+	for level < scoreListMaxLevel-1 && l.rander.Intn(2) == 1 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,129 @@
+package consistent_hash
+
+import "fmt"
+
+// Google maglev 哈希. 基于 HashRing 中记录的节点列表构造固定大小的查找表，每个节点按照各自的排列顺序轮流占据槽位，
+// 节点变更时只有少量槽位会更换归属. 节点的权重取自 HashRing 中记录的虚拟节点个数，决定每一轮可以占据的槽位个数
+type maglevPlacement struct {
+	c         *ConsistentHash
+	tableSize int
+}
+
+func newMaglevPlacement(c *ConsistentHash) placement {
+	return &maglevPlacement{
+		c:         c,
+		tableSize: nextPrime(c.opts.maglevTableSize),
+	}
+}
+
+// 查找表的大小不同，数据的分布也不同，因此需要体现在名称中
+func (m *maglevPlacement) name() string {
+	return fmt.Sprintf("maglev_%d", m.tableSize)
+}
+
+func (m *maglevPlacement) join(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return nil, nil
+}
+
+func (m *maglevPlacement) leave(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return nil, nil
+}
+
+// 权重直接取自 HashRing 中记录的虚拟节点个数，查找表会随快照一同重建
+func (m *maglevPlacement) resize(snapshot *ringSnapshot, nodeID string, oldReplicas, newReplicas int) ([]ringVirtualNode, []ringVirtualNode, error) {
+	return nil, nil, nil
+}
+
+// 构造查找表. 节点 i 的排列为 permutation[i][j] = (offset + j * skip) % tableSize，
+// 由于 tableSize 为质数，每个节点的排列都会覆盖全部槽位
+func (m *maglevPlacement) build(snapshot *ringSnapshot) {
+	if len(snapshot.members) == 0 {
+		return
+	}
+
+	offsets := make([]int, len(snapshot.members))
+	skips := make([]int, len(snapshot.members))
+	for i, nodeID := range snapshot.members {
+		offsets[i] = int(m.c.hash(nodeID+"_offset") % int64(m.tableSize))
+		skips[i] = int(m.c.hash(nodeID+"_skip")%int64(m.tableSize-1)) + 1
+	}
+
+	lookup := make([]int, m.tableSize)
+	for i := range lookup {
+		lookup[i] = -1
+	}
+	nexts := make([]int, len(snapshot.members))
+
+	for filled := 0; filled < m.tableSize; {
+		for i, nodeID := range snapshot.members {
+			weight := snapshot.weights[nodeID]
+			if weight <= 0 {
+				weight = 1
+			}
+			for w := 0; w < weight && filled < m.tableSize; w++ {
+				// 找到该节点排列中下一个未被占据的槽位
+				slot := (offsets[i] + nexts[i]*skips[i]) % m.tableSize
+				for lookup[slot] >= 0 {
+					nexts[i]++
+					slot = (offsets[i] + nexts[i]*skips[i]) % m.tableSize
+				}
+				lookup[slot] = i
+				nexts[i]++
+				filled++
+			}
+		}
+	}
+	snapshot.lookup = lookup
+}
+
+// 节点占据的槽位比例
+func (m *maglevPlacement) ownership(snapshot *ringSnapshot) map[string]float64 {
+	ownership := make(map[string]float64, len(snapshot.members))
+	for _, index := range snapshot.lookup {
+		ownership[snapshot.members[index]] += 1 / float64(len(snapshot.lookup))
+	}
+	return ownership
+}
+
+// 首个副本取数据 key 所在槽位的节点，其余副本沿着查找表向后寻找不同的节点
+func (m *maglevPlacement) locate(snapshot *ringSnapshot, dataKey string, dataScore int64, n int) []string {
+	if len(snapshot.lookup) == 0 {
+		return nil
+	}
+
+	if n > snapshot.nodeCount {
+		n = snapshot.nodeCount
+	}
+
+	slot := int(dataScore % int64(len(snapshot.lookup)))
+	if slot < 0 {
+		slot += len(snapshot.lookup)
+	}
+	nodeIDs := make([]string, 0, n)
+	for i := 0; i < len(snapshot.lookup) && len(nodeIDs) < n; i++ {
+		nodeID := snapshot.members[snapshot.lookup[(slot+i)%len(snapshot.lookup)]]
+		if !contains(nodeIDs, nodeID) {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+	return nodeIDs
+}
+
+// 返回 >= n 的最小质数
+func nextPrime(n int) int {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for i := 2; i*i <= n; i++ {
+			if n%i == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
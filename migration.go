@@ -3,272 +3,277 @@ package consistent_hash
 import (
 	"context"
 	"errors"
-	"math"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
 )
 
 // 用户需要注册好闭包函数进来，核心是执行数据迁移操作的
 type Migrator func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error
 
-func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, nodeID string) (from, to string, datas map[string]struct{}, _err error) {
-	// 使用方没有注入迁移函数，则直接返回
-	if c.migrator == nil {
-		return
-	}
-
-	// 首先根据 virtualScore，查看对应的节点列表，理论上可能存在多个节点共用一个 virtualScore 的情况
-	nodes, err := c.hashRing.Node(ctx, virtualScore)
-	if err != nil {
-		_err = err
-		return
-	}
-
-	// 倘若节点数量大于 1，则说明当前节点不是 virtualScore 的第一个节点，则无需进行迁移
-	if len(nodes) > 1 {
-		return
-	}
+// 一组从 from 节点迁移到 to 节点的数据
+type migrateRoute struct {
+	from, to string
+}
 
-	// 寻找上一个 virtualScore
-	lastScore, err := c.hashRing.Floor(ctx, c.decrScore(virtualScore))
-	if err != nil {
-		_err = err
-		return
+// 对比节点变更前后的哈希环，推算出哪些数据需要从哪个节点迁移到哪个节点，并同步调整数据 key 与节点的映射关系.
+// 一个数据 key 被记录在几个节点下，就视为拥有几个副本，变更后依然需要维持相同的副本数
+func (c *ConsistentHash) migrate(ctx context.Context, tx *ringTx, before, after *ringSnapshot) ([]*MigrationTask, error) {
+	// 注入了基于区间的迁移函数时，无需依赖数据 key 的索引
+	var migrateTasks []*MigrationTask
+	if c.opts.rangeMigrator != nil {
+		rangeTasks, err := c.planRangeMigration(before, after)
+		if err != nil {
+			return nil, err
+		}
+		migrateTasks = append(migrateTasks, rangeTasks...)
 	}
 
-	// 如果没有其他的 virtualScore，说明整个 hash ring 只有当前节点这一个节点，则无需迁移
-	if lastScore == -1 || lastScore == virtualScore {
-		return
+	// 使用方没有注入迁移函数，或者没有记录数据 key，则直接返回
+	if c.migrator == nil || c.dataKeyIndex == nil {
+		return migrateTasks, nil
 	}
 
-	// 寻找下一个 virtualScore
-	nextScore, err := c.hashRing.Ceiling(ctx, c.incrScore(virtualScore))
+	datas, err := c.planMigration(ctx, before, after)
 	if err != nil {
-		_err = err
-		return
-	}
-
-	// 如果没有其他的 virtualScore，说明整个 hash ring 只有当前节点这一个节点，则无需迁移
-	if nextScore == -1 || nextScore == virtualScore {
-		return
-	}
-
-	// patternOne: last-0-cur-next
-	patternOne := lastScore > virtualScore
-	// patternTwo: last-cur-0-next
-	patternTwo := nextScore < virtualScore
-	if patternOne {
-		lastScore -= math.MaxInt32
+		return nil, err
 	}
 
-	if patternTwo {
-		virtualScore -= math.MaxInt32
-		lastScore -= math.MaxInt32
-	}
-
-	// 获取到 nextScore 对应的节点，需要从中获取到所有数据对应的 key
-	nextNodes, err := c.hashRing.Node(ctx, nextScore)
-	if err != nil {
-		_err = err
-		return
+	// 3 调整数据 key 与节点的映射关系，并创建数据迁移任务，但不是立即执行，而是由调用方统一批量执行
+	for route, dataKeys := range datas {
+		if err := tx.MoveDataKeys(ctx, route.from, route.to, dataKeys); err != nil {
+			return nil, err
+		}
 	}
+	return append(migrateTasks, newMigrationTasks(datas)...), nil
+}
 
-	// 找到其中的首个节点，获取到数据 key
-	if len(nextNodes) == 0 {
-		return
+// 推算出哪些数据需要从哪个节点迁移到哪个节点，只读取哈希环，不做任何修改.
+// 迁入节点为空代表剩余节点数不足以容纳全部副本，只需要删除映射关系
+func (c *ConsistentHash) planMigration(ctx context.Context, before, after *ringSnapshot) (map[migrateRoute]map[string]struct{}, error) {
+	// 1 收集变更前后所有节点下的数据 key，得到每个数据 key 当前所在的节点
+	if c.dataKeyIndex == nil {
+		return map[migrateRoute]map[string]struct{}{}, nil
 	}
 
-	dataKeys, err := c.hashRing.DataKeys(ctx, c.getNodeID(nextNodes[0]))
+	// 能够推算出受变更影响的区间时，只扫描可能存放这些数据 key 副本的节点，并且只保留区间内的数据 key.
+	// 同一个数据 key 在各个节点下的 score 相同，因此依然可以收集到数据 key 的全部副本
+	arcs, owners, scanArcs, err := c.affectedArcs(ctx, before, after)
 	if err != nil {
-		_err = err
-		return
+		return nil, err
 	}
+	scoredIndex, scored := c.dataKeyIndex.(ScoredDataKeyIndex)
 
-	datas = make(map[string]struct{})
-	// 遍历数据 key
-	for dataKey := range dataKeys {
-		dataVirtualScore := c.encryptor.Encrypt(dataKey)
-		if patternOne && dataVirtualScore > (lastScore+math.MaxInt32) {
-			dataVirtualScore -= math.MaxInt32
+	holders := make(map[string][]string)
+	for _, nodeID := range unionNodes(before, after) {
+		if _, ok := owners[nodeID]; scanArcs && !ok {
+			continue
 		}
 
-		if patternTwo {
-			dataVirtualScore -= math.MaxInt32
+		var dataKeys map[string]struct{}
+		switch {
+		case !scanArcs:
+			dataKeys, err = c.dataKeyIndex.DataKeys(ctx, nodeID)
+		case scored:
+			dataKeys, err = rangeDataKeys(ctx, scoredIndex, nodeID, arcs)
+		default:
+			dataKeys, err = c.scanDataKeys(ctx, nodeID, arcs)
 		}
-
-		if dataVirtualScore <= lastScore || dataVirtualScore > virtualScore {
-			continue
+		if err != nil {
+			return nil, err
+		}
+		for dataKey := range dataKeys {
+			holders[dataKey] = append(holders[dataKey], nodeID)
 		}
-
-		// 需要迁移的数据
-		datas[dataKey] = struct{}{}
-	}
-
-	if err = c.hashRing.DeleteNodeToDataKeys(ctx, c.getNodeID(nextNodes[0]), datas); err != nil {
-		return "", "", nil, err
-	}
-
-	if err = c.hashRing.AddNodeToDataKeys(ctx, nodeID, datas); err != nil {
-		return "", "", nil, err
 	}
 
-	// from to datas
-	return c.getNodeID(nextNodes[0]), nodeID, datas, nil
-}
-
-func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int32, nodeID string) (from, to string, datas map[string]struct{}, err error) {
-	// 使用方没有注入迁移函数，则直接返回
-	if c.migrator == nil {
-		return
+	// 有界负载模式下，数据的去向取决于各节点的负载，需要按确定的顺序处理数据 key
+	var balancer *boundedBalancer
+	if c.opts.boundedLoads {
+		balancer = c.newBoundedBalancer(after, holders)
 	}
 
-	defer func() {
-		if err != nil {
-			return
+	// 2 基于变更后的哈希环，计算每个数据 key 新的副本列表，与当前所在节点对比
+	datas := make(map[migrateRoute]map[string]struct{})
+	for _, dataKey := range balancer.order(holders) {
+		nodeIDs := holders[dataKey]
+		dataScore := c.hash(dataKey)
+		var newNodes []string
+		if balancer != nil {
+			newNodes = balancer.place(dataKey, dataScore, nodeIDs)
+		} else {
+			newNodes = after.locate(dataKey, dataScore, len(nodeIDs))
 		}
-		if to == "" || len(datas) == 0 {
-			return
+		if len(newNodes) == 0 {
+			return nil, errors.New("no other node")
 		}
 
-		if err = c.hashRing.DeleteNodeToDataKeys(ctx, nodeID, datas); err != nil {
-			return
+		// 按照变更前的副本顺序排列，保证迁出节点与迁入节点的配对是确定的
+		if len(nodeIDs) > 1 {
+			oldNodes := before.locate(dataKey, dataScore, before.nodeCount)
+			sort.SliceStable(nodeIDs, func(i, j int) bool {
+				return indexOf(oldNodes, nodeIDs[i]) < indexOf(oldNodes, nodeIDs[j])
+			})
 		}
 
-		err = c.hashRing.AddNodeToDataKeys(ctx, to, datas)
-	}()
-
-	from = nodeID
-
-	nodes, _err := c.hashRing.Node(ctx, virtualScore)
-	if _err != nil {
-		err = _err
-		return
-	}
-
-	if len(nodes) == 0 {
-		return
-	}
-
-	// 不是 virtualScore 下的首个节点，则无需迁移
-	if c.getNodeID(nodes[0]) != nodeID {
-		return
-	}
-
-	// 如果没有数据，则直接返回
-	var allDatas map[string]struct{}
-	if allDatas, err = c.hashRing.DataKeys(ctx, nodeID); err != nil {
-		return
-	}
-
-	if len(allDatas) == 0 {
-		return
-	}
-
-	// 遍历数据 key，找出其中属于 (lastScore, virtualScore] 范围的数据
-	lastScore, _err := c.hashRing.Floor(ctx, c.decrScore(virtualScore))
-	if _err != nil {
-		err = _err
-		return
-	}
+		var froms, tos []string
+		for _, nodeID := range nodeIDs {
+			if !contains(newNodes, nodeID) {
+				froms = append(froms, nodeID)
+			}
+		}
+		for _, nodeID := range newNodes {
+			if !contains(nodeIDs, nodeID) {
+				tos = append(tos, nodeID)
+			}
+		}
 
-	var onlyScore bool
-	if lastScore == -1 || lastScore == virtualScore {
-		if len(nodes) == 1 {
-			err = errors.New("no other no")
-			return
+		// 剩余节点数不足以容纳全部副本时，多出来的迁出节点没有对应的迁入节点，只需要删除映射关系
+		for i := 0; i < len(froms); i++ {
+			route := migrateRoute{from: froms[i]}
+			if i < len(tos) {
+				route.to = tos[i]
+			}
+			if datas[route] == nil {
+				datas[route] = make(map[string]struct{})
+			}
+			datas[route][dataKey] = struct{}{}
 		}
-		onlyScore = true
 	}
 
-	pattern := lastScore > virtualScore
-	if pattern {
-		lastScore -= math.MaxInt32
-	}
+	return datas, nil
+}
 
-	datas = make(map[string]struct{})
-	for data := range allDatas {
-		if onlyScore {
-			datas[data] = struct{}{}
+// 为每一组迁出、迁入节点创建数据迁移任务，没有迁入节点的数据无需迁移
+func newMigrationTasks(datas map[migrateRoute]map[string]struct{}) []*MigrationTask {
+	migrateTasks := make([]*MigrationTask, 0, len(datas))
+	for route, dataKeys := range datas {
+		if route.to == "" {
 			continue
 		}
-		dataScore := c.encryptor.Encrypt(data)
-		if pattern && dataScore > lastScore+math.MaxInt32 {
-			dataScore -= math.MaxInt32
-		}
-		if dataScore <= lastScore || dataScore > virtualScore {
-			continue
-		}
-		datas[data] = struct{}{}
-	}
-
-	// 如果同一个 virtualScore 下存在多个节点，则直接委托给下一个节点
-	if len(nodes) > 1 {
-		to = c.getNodeID(nodes[1])
-		return
-	}
 
-	// 寻找后继节点
-	if to, err = c.getValidNextNode(ctx, virtualScore, nodeID, nil); err != nil {
-		err = _err
-		return
+		migrateTasks = append(migrateTasks, &MigrationTask{
+			From:     route.from,
+			To:       route.to,
+			DataKeys: dataKeys,
+			KeyCount: len(dataKeys),
+		})
 	}
 
-	if to == "" {
-		err = errors.New("no other node")
-	}
+	// 保证迁移报告中任务的顺序是确定的
+	sort.Slice(migrateTasks, func(i, j int) bool {
+		if migrateTasks[i].From != migrateTasks[j].From {
+			return migrateTasks[i].From < migrateTasks[j].From
+		}
+		return migrateTasks[i].To < migrateTasks[j].To
+	})
+	return migrateTasks
+}
 
-	return
+// 执行所有的数据迁移任务. 失败的任务会按照配置进行退避重试，最终结果记录在迁移报告中.
+// onDone 非空时，每个任务执行结束后都会回调一次
+func (c *ConsistentHash) batchExecuteMigrator(ctx context.Context, migrateTasks []*MigrationTask, onDone func(migrateTask *MigrationTask)) *MigrationReport {
+	// 限制同时执行的迁移任务个数，未配置时不做限制
+	var limiter chan struct{}
+	if c.opts.migrateConcurrency > 0 {
+		limiter = make(chan struct{}, c.opts.migrateConcurrency)
+	}
+
+	var wg sync.WaitGroup
+	for _, migrateTask := range migrateTasks {
+		// shadow
+		migrateTask := migrateTask
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limiter != nil {
+				limiter <- struct{}{}
+				defer func() {
+					<-limiter
+				}()
+			}
+			c.executeMigrator(ctx, migrateTask)
+			if onDone != nil {
+				onDone(migrateTask)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return &MigrationReport{Tasks: migrateTasks}
 }
 
-func (c *ConsistentHash) getValidNextNode(ctx context.Context, score int32, nodeID string, ranged map[int32]struct{}) (string, error) {
-	// 寻找后继节点
-	nextScore, err := c.hashRing.Ceiling(ctx, c.incrScore(score))
-	if err != nil {
-		return "", err
-	}
-	if nextScore == -1 {
-		return "", nil
-	}
+func (c *ConsistentHash) executeMigrator(ctx context.Context, migrateTask *MigrationTask) {
+	backoff := c.opts.migrateRetryBackoff
+	for {
+		// 迁移已经被取消，不再调用 migrator
+		if err := ctx.Err(); err != nil {
+			if migrateTask.Err == nil {
+				migrateTask.Err = err
+			} else {
+				migrateTask.Err = fmt.Errorf("%w, last err: %v", err, migrateTask.Err)
+			}
+			return
+		}
 
-	if _, ok := ranged[nextScore]; ok {
-		return "", nil
-	}
+		migrateTask.Attempts++
+		migrateTask.Panic, migrateTask.Err = c.callMigrator(ctx, migrateTask)
+		if migrateTask.Err == nil {
+			return
+		}
 
-	// 后继节点的 key 必须不与自己相同，否则继续往下寻找
-	nextNodes, err := c.hashRing.Node(ctx, nextScore)
-	if err != nil {
-		return "", err
-	}
+		if migrateTask.Attempts > c.opts.migrateRetryTimes {
+			return
+		}
 
-	if len(nextNodes) == 0 {
-		return "", errors.New("next node empty")
-	}
+		// 退避一段时间后重试，退避时长逐次翻倍，但不超过上限
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
 
-	if nextNode := c.getNodeID(nextNodes[0]); nextNode != nodeID {
-		return nextNode, nil
+		if backoff *= 2; backoff > c.opts.migrateRetryMaxBackoff {
+			backoff = c.opts.migrateRetryMaxBackoff
+		}
 	}
+}
 
-	if len(nextNodes) > 1 {
-		return c.getNodeID(nextNodes[1]), nil
-	}
+// 调用用户注入的迁移函数，panic 会被转换为错误
+func (c *ConsistentHash) callMigrator(ctx context.Context, migrateTask *MigrationTask) (panicValue interface{}, err error) {
+	defer func() {
+		if panicValue = recover(); panicValue != nil {
+			err = fmt.Errorf("migrator panic: %v", panicValue)
+		}
+	}()
 
-	if ranged == nil {
-		ranged = make(map[int32]struct{})
+	if migrateTask.Ranges != nil {
+		return nil, c.opts.rangeMigrator(ctx, migrateTask.Ranges, migrateTask.From, migrateTask.To)
 	}
-	ranged[score] = struct{}{}
-
-	// 寻找下一个目标
-	return c.getValidNextNode(ctx, nextScore, nodeID, ranged)
+	return nil, c.migrator(ctx, migrateTask.DataKeys, migrateTask.From, migrateTask.To)
 }
 
-func (c *ConsistentHash) incrScore(score int32) int32 {
-	if score == math.MaxInt32-1 {
-		return 0
+// 变更前后哈希环上出现过的全部物理节点
+func unionNodes(snapshots ...*ringSnapshot) []string {
+	ranged := make(map[string]struct{})
+	var nodeIDs []string
+	for _, snapshot := range snapshots {
+		for _, nodeID := range snapshot.members {
+			if _, ok := ranged[nodeID]; ok {
+				continue
+			}
+			ranged[nodeID] = struct{}{}
+			nodeIDs = append(nodeIDs, nodeID)
+		}
 	}
-	return score + 1
+	return nodeIDs
 }
 
-func (c *ConsistentHash) decrScore(score int32) int32 {
-	if score == 0 {
-		return math.MaxInt32 - 1
+func indexOf(nodeIDs []string, nodeID string) int {
+	for i, _nodeID := range nodeIDs {
+		if _nodeID == nodeID {
+			return i
+		}
 	}
-	return score - 1
+	return len(nodeIDs)
 }
@@ -0,0 +1,67 @@
+package consistent_hash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 存在迁移失败的任务时，AddNode/RemoveNode 返回的错误会包装该错误，可以通过 errors.Is 判断
+var ErrMigrationFailed = errors.New("migration failed")
+
+// 一次节点变更所触发的数据迁移结果
+type MigrationReport struct {
+	// 异步迁移模式下的迁移任务 id，此时 Tasks 尚未执行
+	JobID string
+	Tasks []*MigrationTask
+}
+
+// 一个数据迁移任务，将 DataKeys 从 From 节点迁移到 To 节点. 基于区间的迁移任务只有 Ranges，没有 DataKeys
+type MigrationTask struct {
+	From     string
+	To       string
+	DataKeys map[string]struct{}
+	KeyCount int
+	// 首个副本由 From 变为 To 的 score 区间，由 RangeMigrator 执行
+	Ranges []ScoreRange
+	// 调用 Migrator 的次数，包含重试
+	Attempts int
+	// 最后一次调用 Migrator 返回的错误，为 nil 代表迁移成功
+	Err error
+	// 最后一次调用 Migrator 时发生 panic 的值
+	Panic interface{}
+}
+
+// 返回迁移失败的任务
+func (m *MigrationReport) FailedTasks() []*MigrationTask {
+	var failedTasks []*MigrationTask
+	for _, task := range m.Tasks {
+		if task.Err != nil {
+			failedTasks = append(failedTasks, task)
+		}
+	}
+	return failedTasks
+}
+
+// 迁移的数据 key 总数
+func (m *MigrationReport) KeyCount() int {
+	var keyCount int
+	for _, task := range m.Tasks {
+		keyCount += task.KeyCount
+	}
+	return keyCount
+}
+
+// 全部任务迁移成功时返回 nil，否则返回包装了 ErrMigrationFailed 的错误
+func (m *MigrationReport) Err() error {
+	failedTasks := m.FailedTasks()
+	if len(failedTasks) == 0 {
+		return nil
+	}
+
+	errMsgs := make([]string, 0, len(failedTasks))
+	for _, task := range failedTasks {
+		errMsgs = append(errMsgs, fmt.Sprintf("from: %s, to: %s, keys: %d, err: %v", task.From, task.To, task.KeyCount, task.Err))
+	}
+	return fmt.Errorf("%w, %d of %d tasks failed: [%s]", ErrMigrationFailed, len(failedTasks), len(m.Tasks), strings.Join(errMsgs, "; "))
+}
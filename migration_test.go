@@ -0,0 +1,260 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_get_nodes_replicas_migration(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	var migrations int64
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		atomic.AddInt64(&migrations, int64(len(dataKeys)))
+		return nil
+	})
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const replicas = 2
+	for i := 0; i < 100; i++ {
+		nodes, err := consistentHash.GetNodes(ctx, fmt.Sprintf("data_%d", i), replicas)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != replicas || nodes[0] == nodes[1] {
+			t.Fatalf("invalid replicas: %v", nodes)
+		}
+	}
+
+	check := func(nodeIDs ...string) {
+		holders := make(map[string][]string)
+		for _, nodeID := range nodeIDs {
+			dataKeys, _ := hashRing.DataKeys(ctx, nodeID)
+			for dataKey := range dataKeys {
+				holders[dataKey] = append(holders[dataKey], nodeID)
+			}
+		}
+
+		if len(holders) != 100 {
+			t.Fatalf("expect 100 data keys, got %d", len(holders))
+		}
+
+		snapshot, _ := consistentHash.loadSnapshot(ctx)
+		for dataKey, _holders := range holders {
+			expect := snapshot.walk(consistentHash.hash(dataKey), replicas)
+			sort.Strings(expect)
+			sort.Strings(_holders)
+			if fmt.Sprint(expect) != fmt.Sprint(_holders) {
+				t.Fatalf("data: %s expect replicas: %v, got: %v", dataKey, expect, _holders)
+			}
+		}
+	}
+
+	if _, err := consistentHash.AddNode(ctx, "node_d", 2); err != nil {
+		t.Fatal(err)
+	}
+	check("node_a", "node_b", "node_c", "node_d")
+	if migrations == 0 {
+		t.Fatal("expect migrations after add node")
+	}
+
+	if _, err := consistentHash.RemoveNode(ctx, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+	check("node_a", "node_c", "node_d")
+}
+
+func Test_migration_report_retry(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mutex sync.Mutex
+		calls = make(map[string]int)
+	)
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		mutex.Lock()
+		calls[from+"->"+to]++
+		call := calls[from+"->"+to]
+		mutex.Unlock()
+
+		// 每个任务第一次调用 panic，第二次调用返回错误，第三次调用成功
+		switch call {
+		case 1:
+			panic("boom")
+		case 2:
+			return errors.New("temporary failure")
+		}
+		return nil
+	}, WithMigrateRetry(2, time.Millisecond, 5*time.Millisecond))
+
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := consistentHash.AddNode(ctx, "node_b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tasks) == 0 || report.KeyCount() == 0 {
+		t.Fatal("expect migration tasks")
+	}
+	for _, task := range report.Tasks {
+		if task.Attempts != 3 || task.Err != nil || task.Panic != nil {
+			t.Fatalf("unexpected task: %+v", task)
+		}
+	}
+}
+
+func Test_migration_report_failed(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		panic("boom")
+	})
+
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := consistentHash.AddNode(ctx, "node_b", 2)
+	if !errors.Is(err, ErrMigrationFailed) {
+		t.Fatalf("expect migration failed, got: %v", err)
+	}
+	if len(report.FailedTasks()) != len(report.Tasks) {
+		t.Fatalf("expect all tasks failed, got: %d of %d", len(report.FailedTasks()), len(report.Tasks))
+	}
+	for _, task := range report.Tasks {
+		if task.Panic != "boom" || task.Attempts != 1 {
+			t.Fatalf("unexpected task: %+v", task)
+		}
+	}
+}
+
+func Test_update_node_weight(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithReplicas(5))
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 300; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(replicas int) {
+		snapshot, _ := consistentHash.loadSnapshot(ctx)
+		var virtualNodes int
+		for _, nodeIDs := range snapshot.nodes {
+			virtualNodes += len(nodeIDs)
+		}
+		if virtualNodes != replicas+10 || snapshot.weights["node_a"] != replicas {
+			t.Fatalf("expect node_a with %d virtual nodes, got: %d of %d", replicas, snapshot.weights["node_a"], virtualNodes)
+		}
+
+		for _, nodeID := range snapshot.members {
+			dataKeys, _ := hashRing.DataKeys(ctx, nodeID)
+			for dataKey := range dataKeys {
+				if expect := snapshot.walk(consistentHash.hash(dataKey), 1)[0]; expect != nodeID {
+					t.Fatalf("data: %s expect node: %s, got: %s", dataKey, expect, nodeID)
+				}
+			}
+		}
+	}
+
+	// 调高权重时，数据只会迁入 node_a
+	report, err := consistentHash.UpdateNodeWeight(ctx, "node_a", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.KeyCount() == 0 {
+		t.Fatal("expect migrations after update node weight")
+	}
+	for _, task := range report.Tasks {
+		if task.To != "node_a" {
+			t.Fatalf("unexpected task: %s -> %s", task.From, task.To)
+		}
+	}
+	check(15)
+
+	// 调低权重时，数据只会从 node_a 迁出
+	if report, err = consistentHash.UpdateNodeWeight(ctx, "node_a", 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range report.Tasks {
+		if task.From != "node_a" {
+			t.Fatalf("unexpected task: %s -> %s", task.From, task.To)
+		}
+	}
+	check(10)
+
+	if _, err = consistentHash.UpdateNodeWeight(ctx, "node_d", 1); err == nil {
+		t.Fatal("expect error when updating a missing node")
+	}
+}
+
+func Test_node_weight_replicas(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil,
+		WithReplicas(4), WithMaxVirtualNodes(200), WithVirtualNodeBudget(300))
+
+	for _, weight := range []float64{0, -1, math.NaN(), math.Inf(1), 64} {
+		if _, err := consistentHash.AddNode(ctx, "node_a", weight); !errors.Is(err, ErrInvalidWeight) {
+			t.Fatalf("weight: %v expect invalid weight, got: %v", weight, err)
+		}
+	}
+
+	if _, err := consistentHash.AddNode(ctx, "node_a", 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.AddNode(ctx, "node_b", 40); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超出虚拟节点总预算
+	if _, err := consistentHash.AddNode(ctx, "node_c", 40); !errors.Is(err, ErrInvalidWeight) {
+		t.Fatalf("expect invalid weight, got: %v", err)
+	}
+
+	if _, err := consistentHash.UpdateNodeWeight(ctx, "node_b", 30); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.AddNode(ctx, "node_c", 40); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, _ := hashRing.Nodes(ctx)
+	expect := map[string]int{"node_a": 2, "node_b": 120, "node_c": 160}
+	if fmt.Sprint(nodes) != fmt.Sprint(expect) {
+		t.Fatalf("expect replicas: %v, got: %v", expect, nodes)
+	}
+}
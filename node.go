@@ -0,0 +1,236 @@
+package consistent_hash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 节点的元数据，存储在哈希环中，使用方无需另外维护 nodeID 到地址的映射
+type NodeMeta struct {
+	Address string            `json:"address,omitempty"`
+	Zone    string            `json:"zone,omitempty"`
+	Rack    string            `json:"rack,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// 节点的状态
+type NodeState string
+
+const (
+	// 正常提供服务
+	NodeStateActive NodeState = "active"
+	// 即将下线，不再接收新的路由
+	NodeStateDraining NodeState = "draining"
+	// 暂时不可用，例如重启期间
+	NodeStateDown NodeState = "down"
+)
+
+func (n NodeState) valid() bool {
+	return n == NodeStateActive || n == NodeStateDraining || n == NodeStateDown
+}
+
+// 节点的信息
+type NodeInfo struct {
+	NodeID string
+	// 节点对应的虚拟节点个数
+	Replicas int
+	Meta     NodeMeta
+	State    NodeState
+}
+
+type NodeOptions struct {
+	meta *NodeMeta
+}
+
+type NodeOption func(opts *NodeOptions)
+
+// 添加节点时一并写入节点的元数据
+func WithNodeMeta(meta NodeMeta) NodeOption {
+	return func(opts *NodeOptions) {
+		opts.meta = &meta
+	}
+}
+
+// 查询节点的信息
+func (c *ConsistentHash) NodeInfo(ctx context.Context, nodeID string) (*NodeInfo, error) {
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := snapshot.weights[nodeID]; !ok {
+		return nil, errors.New("invalid node id")
+	}
+	return snapshot.nodeInfo(nodeID), nil
+}
+
+// 查询全部节点的信息，按照 nodeID 升序排列
+func (c *ConsistentHash) NodeInfos(ctx context.Context) ([]*NodeInfo, error) {
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeInfos := make([]*NodeInfo, 0, len(snapshot.members))
+	for _, nodeID := range snapshot.members {
+		nodeInfos = append(nodeInfos, snapshot.nodeInfo(nodeID))
+	}
+	return nodeInfos, nil
+}
+
+// 与 GetNode 一致，同时返回节点的信息
+func (c *ConsistentHash) GetNodeInfo(ctx context.Context, dataKey string) (*NodeInfo, error) {
+	nodeInfos, err := c.GetNodeInfos(ctx, dataKey, 1)
+	if err != nil {
+		return nil, err
+	}
+	return nodeInfos[0], nil
+}
+
+// 与 GetNodes 一致，同时返回每个副本节点的信息
+func (c *ConsistentHash) GetNodeInfos(ctx context.Context, dataKey string, n int) ([]*NodeInfo, error) {
+	snapshot, nodes, err := c.getNodes(ctx, dataKey, n)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeInfos := make([]*NodeInfo, 0, len(nodes))
+	for _, nodeID := range nodes {
+		nodeInfos = append(nodeInfos, snapshot.nodeInfo(nodeID))
+	}
+	return nodeInfos, nil
+}
+
+// 更新节点的元数据，不影响哈希环的拓扑，也不会触发数据迁移
+func (c *ConsistentHash) UpdateNodeMeta(ctx context.Context, nodeID string, meta NodeMeta) error {
+	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := nodes[nodeID]; !ok {
+		return errors.New("invalid node id")
+	}
+
+	version, err := c.commitMeta(ctx, func(tx *ringTx) error {
+		return c.setNodeMeta(ctx, tx, nodeID, meta)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.publishEvent(ctx, &RingEvent{
+		Type:     RingEventNodeMetaChanged,
+		NodeID:   nodeID,
+		Version:  version,
+		Replicas: nodes[nodeID],
+	})
+	return nil
+}
+
+// 设置节点的状态. 非 active 的节点不参与路由，GetNode 会顺延到下一个不同的 active 节点，
+// 但节点依然保留在哈希环上，不会改变虚拟节点的分布，也不会触发数据迁移. 重新设置为 active 后立即恢复路由
+func (c *ConsistentHash) SetNodeState(ctx context.Context, nodeID string, state NodeState) error {
+	if !state.valid() {
+		return fmt.Errorf("invalid node state: %s", state)
+	}
+
+	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := nodes[nodeID]; !ok {
+		return errors.New("invalid node id")
+	}
+
+	version, err := c.commitMeta(ctx, func(tx *ringTx) error {
+		// 哈希环中只记录非 active 状态的节点
+		if state == NodeStateActive {
+			return tx.DeleteNodeState(ctx, nodeID)
+		}
+		return tx.SetNodeState(ctx, nodeID, string(state))
+	})
+	if err != nil {
+		return err
+	}
+
+	c.publishEvent(ctx, &RingEvent{
+		Type:     RingEventNodeStateChanged,
+		NodeID:   nodeID,
+		Version:  version,
+		Replicas: nodes[nodeID],
+		State:    state,
+	})
+	return nil
+}
+
+// 查询全部节点的状态
+func (c *ConsistentHash) NodeStates(ctx context.Context) (map[string]NodeState, error) {
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]NodeState, len(snapshot.members))
+	for _, nodeID := range snapshot.members {
+		states[nodeID] = snapshot.nodeState(nodeID)
+	}
+	return states, nil
+}
+
+func (c *ConsistentHash) setNodeMeta(ctx context.Context, tx *ringTx, nodeID string, meta NodeMeta) error {
+	body, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return tx.SetNodeMeta(ctx, nodeID, string(body))
+}
+
+func (r *ringSnapshot) nodeInfo(nodeID string) *NodeInfo {
+	meta := r.metas[nodeID]
+	// 返回副本，避免使用方修改快照中的数据
+	if meta.Labels != nil {
+		labels := make(map[string]string, len(meta.Labels))
+		for key, val := range meta.Labels {
+			labels[key] = val
+		}
+		meta.Labels = labels
+	}
+
+	return &NodeInfo{
+		NodeID:   nodeID,
+		Replicas: r.weights[nodeID],
+		Meta:     meta,
+		State:    r.nodeState(nodeID),
+	}
+}
+
+func (r *ringSnapshot) nodeState(nodeID string) NodeState {
+	if state, ok := r.states[nodeID]; ok {
+		return state
+	}
+	return NodeStateActive
+}
+
+func (r *ringSnapshot) active(nodeID string) bool {
+	return r.nodeState(nodeID) == NodeStateActive
+}
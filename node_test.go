@@ -0,0 +1,161 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_node_meta(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	var migrations int
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		migrations++
+		return nil
+	})
+
+	metaA := NodeMeta{Address: "10.0.0.1:6379", Zone: "zone_a", Rack: "rack_1", Labels: map[string]string{"disk": "ssd"}}
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1, WithNodeMeta(metaA)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeInfo, err := consistentHash.NodeInfo(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeInfo.Replicas != 5 || !reflect.DeepEqual(nodeInfo.Meta, metaA) {
+		t.Fatalf("unexpected node info: %+v", nodeInfo)
+	}
+
+	for i := 0; i < 50; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeInfo, err := consistentHash.GetNodeInfo(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, _ := consistentHash.GetNode(ctx, dataKey)
+		if nodeInfo.NodeID != node {
+			t.Fatalf("data: %s expect node: %s, got: %s", dataKey, node, nodeInfo.NodeID)
+		}
+		if node == "node_a" && nodeInfo.Meta.Address != metaA.Address {
+			t.Fatalf("unexpected node info: %+v", nodeInfo)
+		}
+	}
+
+	// 更新元数据不影响哈希环的拓扑
+	before, _ := consistentHash.loadSnapshot(ctx)
+	metaB := NodeMeta{Address: "10.0.0.2:6379", Zone: "zone_b"}
+	if err = consistentHash.UpdateNodeMeta(ctx, "node_b", metaB); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := consistentHash.loadSnapshot(ctx)
+	if after.version <= before.version || !reflect.DeepEqual(after.scores, before.scores) || migrations != 0 {
+		t.Fatal("update node meta should only bump version")
+	}
+
+	nodeInfos, err := consistentHash.NodeInfos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodeInfos) != 2 || !reflect.DeepEqual(nodeInfos[1].Meta, metaB) {
+		t.Fatalf("unexpected node infos: %+v", nodeInfos)
+	}
+
+	if err = consistentHash.UpdateNodeMeta(ctx, "node_c", metaB); err == nil {
+		t.Fatal("expect error when updating a missing node")
+	}
+
+	if _, err = consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	metas, _ := hashRing.NodeMetas(ctx)
+	if _, ok := metas["node_a"]; ok {
+		t.Fatal("expect node meta removed with node")
+	}
+}
+
+func Test_node_state(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	var migrations int
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		migrations++
+		return nil
+	})
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	routes := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes[dataKey] = node
+	}
+
+	before, _ := consistentHash.loadSnapshot(ctx)
+	if err := consistentHash.SetNodeState(ctx, "node_a", NodeStateDown); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := consistentHash.loadSnapshot(ctx)
+	if !reflect.DeepEqual(after.scores, before.scores) || migrations != 0 {
+		t.Fatal("set node state should not change the ring")
+	}
+
+	// 非 active 的节点被跳过，由下一个不同的 active 节点顶替
+	for dataKey, node := range routes {
+		routed, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if routed == "node_a" {
+			t.Fatalf("data: %s routed to down node", dataKey)
+		}
+		if node != "node_a" && routed != node {
+			t.Fatalf("data: %s expect node: %s, got: %s", dataKey, node, routed)
+		}
+		if expect := after.walk(consistentHash.hash(dataKey), 2)[1]; node == "node_a" && routed != expect {
+			t.Fatalf("data: %s expect node: %s, got: %s", dataKey, expect, routed)
+		}
+	}
+
+	// 数据 key 依然记录在原节点下
+	dataKeys, _ := hashRing.DataKeys(ctx, "node_a")
+	for dataKey := range dataKeys {
+		if routes[dataKey] != "node_a" {
+			t.Fatalf("data: %s should not be recorded in node_a", dataKey)
+		}
+	}
+
+	states, _ := consistentHash.NodeStates(ctx)
+	if states["node_a"] != NodeStateDown || states["node_b"] != NodeStateActive {
+		t.Fatalf("unexpected node states: %v", states)
+	}
+
+	// 恢复为 active 后立即恢复路由
+	if err := consistentHash.SetNodeState(ctx, "node_a", NodeStateActive); err != nil {
+		t.Fatal(err)
+	}
+	for dataKey, node := range routes {
+		if routed, _ := consistentHash.GetNode(ctx, dataKey); routed != node {
+			t.Fatalf("data: %s expect node: %s, got: %s", dataKey, node, routed)
+		}
+	}
+
+	if err := consistentHash.SetNodeState(ctx, "node_a", NodeState("unknown")); err == nil {
+		t.Fatal("expect error when setting an invalid state")
+	}
+}
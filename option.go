@@ -1,8 +1,42 @@
 package consistent_hash
 
+import "time"
+
 type ConsistentHashOptions struct {
 	lockExpireSeconds int
-	replicas          int
+	// 权重为 1 的节点对应的虚拟节点个数
+	replicas int
+	// 单个节点虚拟节点个数的上限，以及整个哈希环虚拟节点的总预算，<= 0 代表不做限制
+	maxVirtualNodes   int
+	virtualNodeBudget int
+	// 迁移失败后的重试次数，以及重试的退避时长
+	migrateRetryTimes      int
+	migrateRetryBackoff    time.Duration
+	migrateRetryMaxBackoff time.Duration
+	// 同时执行的迁移任务个数，<= 0 代表不做限制
+	migrateConcurrency int
+	// 异步迁移模式下，AddNode/RemoveNode 提交哈希环的变更后立即返回迁移任务 id
+	asyncMigration bool
+	// 轮询迁移任务状态的间隔
+	migrationJobPollInterval time.Duration
+	// 迁移任务结束后，其状态在哈希环中保留的时长
+	migrationJobTTL time.Duration
+	// 有界负载模式，每个节点的负载上限为 ceil((1+ε) * 平均负载)
+	boundedLoads        bool
+	boundedLoadsEpsilon float64
+	// 数据的放置策略，默认为基于有序虚拟节点表的一致性哈希
+	newPlacement func(c *ConsistentHash) placement
+	// maglev 查找表的大小
+	maglevTableSize int
+	// 虚拟节点的命名方式
+	vnodeKeyer VnodeKeyer
+	// 数据 key 的索引与记录时机
+	dataKeyIndex    DataKeyIndex
+	dataKeyTracking DataKeyTracking
+	// 记录数据 key 时设置的过期时长，每次记录都会顺延. <= 0 代表永不过期
+	dataKeyTTL time.Duration
+	// 基于 score 区间的迁移函数
+	rangeMigrator RangeMigrator
 }
 
 type ConsistentHashOption func(opts *ConsistentHashOptions)
@@ -19,6 +53,134 @@ func WithReplicas(replicas int) ConsistentHashOption {
 	}
 }
 
+// 单个节点最多使用的虚拟节点个数，权重换算后超出上限时，AddNode/UpdateNodeWeight 返回错误
+func WithMaxVirtualNodes(maxVirtualNodes int) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.maxVirtualNodes = maxVirtualNodes
+	}
+}
+
+// 整个哈希环最多使用的虚拟节点个数，超出预算时，AddNode/UpdateNodeWeight 返回错误
+func WithVirtualNodeBudget(budget int) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.virtualNodeBudget = budget
+	}
+}
+
+// 迁移失败后最多重试 times 次，首次重试前等待 backoff，此后等待时长逐次翻倍，不超过 maxBackoff
+func WithMigrateRetry(times int, backoff, maxBackoff time.Duration) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.migrateRetryTimes = times
+		opts.migrateRetryBackoff = backoff
+		opts.migrateRetryMaxBackoff = maxBackoff
+	}
+}
+
+func WithMigrateConcurrency(concurrency int) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.migrateConcurrency = concurrency
+	}
+}
+
+// 开启异步迁移模式，迁移任务的状态存储在哈希环中，可以通过 MigrationJob 查询
+func WithAsyncMigration() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.asyncMigration = true
+	}
+}
+
+func WithMigrationJobPollInterval(interval time.Duration) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.migrationJobPollInterval = interval
+	}
+}
+
+// 迁移任务结束后，其状态在哈希环中保留的时长，默认为 24 小时. 到期后无法再通过 MigrationJob 查询
+func WithMigrationJobTTL(ttl time.Duration) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.migrationJobTTL = ttl
+	}
+}
+
+// 开启有界负载模式. 数据 key 所属的节点负载达到上限时，会顺延到下一个节点.
+// 节点的负载取自数据 key 与节点的映射关系，因此需要注入 Migrator 以维持映射关系的准确
+func WithBoundedLoads(epsilon float64) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.boundedLoads = true
+		opts.boundedLoadsEpsilon = epsilon
+	}
+}
+
+// 使用 jump consistent hash 放置数据，适用于编号连续的分片集群.
+// 节点只能追加到末尾或者从末尾移除，节点的权重不生效
+func WithJumpHash() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.newPlacement = newJumpPlacement
+	}
+}
+
+// 使用带权重的 rendezvous 哈希放置数据，不需要维护虚拟节点，适用于节点个数较少的场景
+func WithRendezvousHash() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.newPlacement = newRendezvousPlacement
+	}
+}
+
+// 使用 maglev 查找表放置数据，查询的时间复杂度为 O(1). 查找表的大小需要远大于节点个数，
+// 非质数时会向上取整到质数，<= 0 时使用默认值 65537
+func WithMaglevHash(tableSize int) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.newPlacement = newMaglevPlacement
+		opts.maglevTableSize = tableSize
+	}
+}
+
+// 使用与 libketama 兼容的一致性哈希，与使用 ketama 的 memcached 客户端共享相同的路由结果.
+// 节点 id 需要与其他客户端使用的服务端地址一致，例如 "10.0.0.1:11211". 节点的权重对应 libketama 中的 memory，
+// 为了与其他客户端的权重占比完全一致，建议搭配 WithReplicas(1) 使用整数权重
+func WithKetama() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.newPlacement = newKetamaPlacement
+	}
+}
+
+// 指定虚拟节点的命名方式，默认为 "nodeID_index"
+func WithVnodeKeyer(vnodeKeyer VnodeKeyer) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.vnodeKeyer = vnodeKeyer
+	}
+}
+
+// 指定数据 key 的索引. 未指定时，若哈希环实现了 DataKeyIndex 则使用哈希环自身的索引
+func WithDataKeyIndex(dataKeyIndex DataKeyIndex) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.dataKeyIndex = dataKeyIndex
+	}
+}
+
+// 指定记录数据 key 的时机，默认为 DataKeyTrackingLookup
+func WithDataKeyTracking(tracking DataKeyTracking) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.dataKeyTracking = tracking
+	}
+}
+
+// 指定数据 key 的过期时长. GetNode/Register 记录数据 key 时顺延过期时间，长期未被访问的数据 key 会从索引中淘汰
+func WithDataKeyTTL(ttl time.Duration) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.dataKeyTTL = ttl
+	}
+}
+
+// 指定基于 score 区间的迁移函数. 节点变更时为首个副本发生变化的区间调用该函数，不依赖数据 key 的索引，
+// 只支持按照 score 区间描述数据归属的放置策略，与 jump、rendezvous、maglev、ketama 或有界负载同时指定时
+// NewConsistentHashE 返回 ErrRangesUnsupported，NewConsistentHash 则在变更哈希环时返回该错误. 与 Migrator 同时指定时两者都会被调用
+func WithRangeMigrator(rangeMigrator RangeMigrator) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.rangeMigrator = rangeMigrator
+	}
+}
+
 func repair(opts *ConsistentHashOptions) {
 	// 没指定，则代表无超时时限
 	if opts.lockExpireSeconds <= 0 {
@@ -28,4 +190,41 @@ func repair(opts *ConsistentHashOptions) {
 	if opts.replicas <= 0 {
 		opts.replicas = 5
 	}
+
+	// 默认不重试
+	if opts.migrateRetryTimes < 0 {
+		opts.migrateRetryTimes = 0
+	}
+
+	if opts.migrateRetryBackoff <= 0 {
+		opts.migrateRetryBackoff = 100 * time.Millisecond
+	}
+
+	if opts.migrateRetryMaxBackoff < opts.migrateRetryBackoff {
+		opts.migrateRetryMaxBackoff = opts.migrateRetryBackoff
+	}
+
+	if opts.boundedLoads && opts.boundedLoadsEpsilon <= 0 {
+		opts.boundedLoadsEpsilon = 0.25
+	}
+
+	if opts.migrationJobPollInterval <= 0 {
+		opts.migrationJobPollInterval = 500 * time.Millisecond
+	}
+
+	if opts.migrationJobTTL <= 0 {
+		opts.migrationJobTTL = 24 * time.Hour
+	}
+
+	if opts.maglevTableSize <= 0 {
+		opts.maglevTableSize = 65537
+	}
+
+	if opts.vnodeKeyer == nil {
+		opts.vnodeKeyer = DefaultVnodeKeyer{}
+	}
+
+	if opts.newPlacement == nil {
+		opts.newPlacement = newRingPlacement
+	}
 }
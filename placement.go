@@ -0,0 +1,241 @@
+package consistent_hash
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// 哈希环上的一个虚拟节点，index 为虚拟节点在所属节点中的下标
+type ringVirtualNode struct {
+	score  int64
+	nodeID string
+	index  int
+}
+
+// 数据的放置策略. 节点的成员关系统一存储在 HashRing 中，放置策略决定节点加入、退出时需要写入、删除哪些虚拟节点，
+// 以及如何基于快照定位数据 key 所属的节点. 不同的放置策略共享 AddNode/RemoveNode/GetNode/Migrator 的语义
+type placement interface {
+	// 放置策略的名称，记录在哈希环的配置中
+	name() string
+	// 节点加入时需要写入哈希环的虚拟节点
+	join(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error)
+	// 节点退出时需要从哈希环中删除的虚拟节点
+	leave(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error)
+	// 节点的虚拟节点个数由 oldReplicas 调整为 newReplicas 时，需要写入与删除的虚拟节点
+	resize(snapshot *ringSnapshot, nodeID string, oldReplicas, newReplicas int) (adds, rems []ringVirtualNode, err error)
+	// 每个物理节点作为首个副本所拥有的哈希空间比例
+	ownership(snapshot *ringSnapshot) map[string]float64
+	// 构造快照时调用，预先计算查询所需的数据结构
+	build(snapshot *ringSnapshot)
+	// 返回数据 key 的前 n 个不同的物理节点，物理节点不足 n 个时返回全部物理节点
+	locate(snapshot *ringSnapshot, dataKey string, dataScore int64, n int) []string
+}
+
+// 基于有序虚拟节点表的一致性哈希，对应 local 中的跳表与 redis 中的 zset
+type ringPlacement struct {
+	c *ConsistentHash
+}
+
+func newRingPlacement(c *ConsistentHash) placement {
+	return &ringPlacement{c: c}
+}
+
+func (r *ringPlacement) name() string {
+	return "ring"
+}
+
+func (r *ringPlacement) join(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return r.virtualNodes(nodeID, replicas), nil
+}
+
+func (r *ringPlacement) leave(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return r.virtualNodes(nodeID, replicas), nil
+}
+
+// 只增删下标位于 [oldReplicas, newReplicas) 或 [newReplicas, oldReplicas) 之间的虚拟节点，其余虚拟节点保持不动
+func (r *ringPlacement) resize(snapshot *ringSnapshot, nodeID string, oldReplicas, newReplicas int) ([]ringVirtualNode, []ringVirtualNode, error) {
+	if newReplicas > oldReplicas {
+		return r.virtualNodesBetween(nodeID, oldReplicas, newReplicas), nil, nil
+	}
+	return nil, r.virtualNodesBetween(nodeID, newReplicas, oldReplicas), nil
+}
+
+// 使用 encryptor，推算出节点对应的 replicas 个虚拟节点的数值
+func (r *ringPlacement) virtualNodes(nodeID string, replicas int) []ringVirtualNode {
+	return r.virtualNodesBetween(nodeID, 0, replicas)
+}
+
+// 推算出节点下标位于 [from, to) 之间的虚拟节点
+func (r *ringPlacement) virtualNodesBetween(nodeID string, from, to int) []ringVirtualNode {
+	virtualNodes := make([]ringVirtualNode, 0, to-from)
+	for i := from; i < to; i++ {
+		virtualNodes = append(virtualNodes, ringVirtualNode{
+			score:  r.c.hash(r.c.opts.vnodeKeyer.VnodeKey(nodeID, i)),
+			nodeID: nodeID,
+			index:  i,
+		})
+	}
+	return virtualNodes
+}
+
+func (r *ringPlacement) build(snapshot *ringSnapshot) {}
+
+// 每个虚拟节点拥有 (前一个虚拟节点, 当前虚拟节点] 之间的弧，首个虚拟节点的弧跨越环尾
+func (r *ringPlacement) ownership(snapshot *ringSnapshot) map[string]float64 {
+	ownership := make(map[string]float64, len(snapshot.members))
+	space := float64(r.c.hashSpace())
+	for i, score := range snapshot.scores {
+		// 64 位哈希空间下 score 之差可能溢出 int64，使用浮点数计算弧长
+		var prev float64
+		if i == 0 {
+			prev = float64(snapshot.scores[len(snapshot.scores)-1]) - space
+		} else {
+			prev = float64(snapshot.scores[i-1])
+		}
+		ownership[snapshot.nodes[i][0]] += (float64(score) - prev) / space
+	}
+	return ownership
+}
+
+func (r *ringPlacement) locate(snapshot *ringSnapshot, dataKey string, dataScore int64, n int) []string {
+	return snapshot.walk(dataScore, n)
+}
+
+// Lamping & Veach 的 jump consistent hash. 每个分片在哈希环中只占据一个位置，score 即为分片的编号，
+// 节点只能追加到末尾，也只能从末尾移除. 分片之间天然均衡，因此节点的权重不生效
+type jumpPlacement struct {
+	c *ConsistentHash
+}
+
+func newJumpPlacement(c *ConsistentHash) placement {
+	return &jumpPlacement{c: c}
+}
+
+func (j *jumpPlacement) name() string {
+	return "jump"
+}
+
+func (j *jumpPlacement) join(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return []ringVirtualNode{{
+		score:  int64(len(snapshot.scores)),
+		nodeID: nodeID,
+	}}, nil
+}
+
+func (j *jumpPlacement) leave(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	last := len(snapshot.scores) - 1
+	if last < 0 || !contains(snapshot.nodes[last], nodeID) {
+		return nil, errors.New("jump hash only supports removing the last node")
+	}
+
+	return []ringVirtualNode{{
+		score:  snapshot.scores[last],
+		nodeID: nodeID,
+	}}, nil
+}
+
+// 每个分片只占据一个位置，权重的调整不影响数据的分布
+func (j *jumpPlacement) resize(snapshot *ringSnapshot, nodeID string, oldReplicas, newReplicas int) ([]ringVirtualNode, []ringVirtualNode, error) {
+	return nil, nil, nil
+}
+
+func (j *jumpPlacement) build(snapshot *ringSnapshot) {}
+
+// 每个分片均分哈希空间
+func (j *jumpPlacement) ownership(snapshot *ringSnapshot) map[string]float64 {
+	ownership := make(map[string]float64, len(snapshot.members))
+	for _, nodeID := range snapshot.members {
+		ownership[nodeID] = 1 / float64(len(snapshot.members))
+	}
+	return ownership
+}
+
+// 首个副本由 jump hash 决定，其余副本依次取编号递增的分片
+func (j *jumpPlacement) locate(snapshot *ringSnapshot, dataKey string, dataScore int64, n int) []string {
+	if len(snapshot.scores) == 0 {
+		return nil
+	}
+	return snapshot.walkFrom(jumpHash(uint64(dataScore), len(snapshot.scores)), n)
+}
+
+// 将 key 映射到 [0, buckets) 中的一个分片. 分片个数由 n 增加到 n+1 时，只有 1/(n+1) 的 key 会移动到新分片上
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// 带权重的 rendezvous (HRW) 哈希. 使用 encryptor 为每一组 (节点, 数据 key) 打分，分数最高的节点获得数据，
+// 不需要虚拟节点，节点的权重取自 HashRing 中记录的虚拟节点个数
+type rendezvousPlacement struct {
+	c *ConsistentHash
+}
+
+func newRendezvousPlacement(c *ConsistentHash) placement {
+	return &rendezvousPlacement{c: c}
+}
+
+func (r *rendezvousPlacement) name() string {
+	return "rendezvous"
+}
+
+func (r *rendezvousPlacement) join(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return nil, nil
+}
+
+func (r *rendezvousPlacement) leave(snapshot *ringSnapshot, nodeID string, replicas int) ([]ringVirtualNode, error) {
+	return nil, nil
+}
+
+// 权重直接取自 HashRing 中记录的虚拟节点个数，无需调整虚拟节点
+func (r *rendezvousPlacement) resize(snapshot *ringSnapshot, nodeID string, oldReplicas, newReplicas int) ([]ringVirtualNode, []ringVirtualNode, error) {
+	return nil, nil, nil
+}
+
+func (r *rendezvousPlacement) build(snapshot *ringSnapshot) {}
+
+// 节点获得数据的概率与其权重成正比
+func (r *rendezvousPlacement) ownership(snapshot *ringSnapshot) map[string]float64 {
+	var total int
+	for _, nodeID := range snapshot.members {
+		total += snapshot.weights[nodeID]
+	}
+
+	ownership := make(map[string]float64, len(snapshot.members))
+	for _, nodeID := range snapshot.members {
+		ownership[nodeID] = float64(snapshot.weights[nodeID]) / float64(total)
+	}
+	return ownership
+}
+
+func (r *rendezvousPlacement) locate(snapshot *ringSnapshot, dataKey string, dataScore int64, n int) []string {
+	if n > snapshot.nodeCount {
+		n = snapshot.nodeCount
+	}
+
+	scores := make(map[string]float64, len(snapshot.members))
+	for _, nodeID := range snapshot.members {
+		scores[nodeID] = r.score(nodeID, dataKey, snapshot.weights[nodeID])
+	}
+
+	// members 已经有序，分数相同时按照节点 id 排序，保证结果是确定的
+	nodeIDs := make([]string, len(snapshot.members))
+	copy(nodeIDs, snapshot.members)
+	sort.SliceStable(nodeIDs, func(i, j int) bool {
+		return scores[nodeIDs[i]] > scores[nodeIDs[j]]
+	})
+	return nodeIDs[:n]
+}
+
+// 加权打分：weight / -ln(h)，其中 h 为 (节点, 数据 key) 哈希值归一化到 (0, 1) 后的结果.
+// 节点获得数据的概率与其权重成正比
+func (r *rendezvousPlacement) score(nodeID, dataKey string, weight int) float64 {
+	hash := r.c.hash(nodeID + "_" + dataKey)
+	h := (float64(hash) + 1) / (float64(r.c.hashSpace()) + 1)
+	return float64(weight) / -math.Log(h)
+}
@@ -0,0 +1,269 @@
+package consistent_hash
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_jump_hash_placement(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithJumpHash())
+
+	for i := 0; i < 4; i++ {
+		if _, err := consistentHash.AddNode(ctx, fmt.Sprintf("shard_%d", i), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const dataKeys = 1000
+	loads := make(map[string]int)
+	for i := 0; i < dataKeys; i++ {
+		node, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		loads[node]++
+	}
+	for node, load := range loads {
+		if load < dataKeys/4*3/4 || load > dataKeys/4*5/4 {
+			t.Fatalf("node: %s unbalanced load: %d", node, load)
+		}
+	}
+
+	// 追加分片时，数据只会迁移到新分片上
+	report, err := consistentHash.AddNode(ctx, "shard_4", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.KeyCount() == 0 {
+		t.Fatal("expect migrations after add node")
+	}
+	for _, task := range report.Tasks {
+		if task.To != "shard_4" {
+			t.Fatalf("unexpected task: %s -> %s", task.From, task.To)
+		}
+	}
+
+	if _, err = consistentHash.RemoveNode(ctx, "shard_1"); err == nil {
+		t.Fatal("expect error when removing a middle shard")
+	}
+
+	// 移除末尾分片时，数据只会从该分片迁出，并回到追加前的位置
+	if report, err = consistentHash.RemoveNode(ctx, "shard_4"); err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range report.Tasks {
+		if task.From != "shard_4" {
+			t.Fatalf("unexpected task: %s -> %s", task.From, task.To)
+		}
+	}
+	for node, load := range loads {
+		count, _ := hashRing.DataKeysCount(ctx, node)
+		if count != load {
+			t.Fatalf("node: %s expect load: %d, got: %d", node, load, count)
+		}
+	}
+}
+
+func Test_rendezvous_hash_placement(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithRendezvousHash())
+
+	// node_c 的权重是其他节点的两倍
+	for nodeID, weight := range map[string]float64{"node_a": 1, "node_b": 1, "node_c": 2} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const dataKeys = 2000
+	for i := 0; i < dataKeys; i++ {
+		nodes, err := consistentHash.GetNodes(ctx, fmt.Sprintf("data_%d", i), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 2 || nodes[0] == nodes[1] {
+			t.Fatalf("invalid replicas: %v", nodes)
+		}
+	}
+
+	snapshot, _ := consistentHash.loadSnapshot(ctx)
+	if len(snapshot.scores) != 0 {
+		t.Fatalf("expect no virtual nodes, got: %d", len(snapshot.scores))
+	}
+
+	primaries := make(map[string]int)
+	for i := 0; i < dataKeys; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		primaries[snapshot.locate(dataKey, consistentHash.hash(dataKey), 1)[0]]++
+	}
+	if primaries["node_c"] < dataKeys*4/10 || primaries["node_c"] > dataKeys*6/10 {
+		t.Fatalf("unexpected weighted distribution: %v", primaries)
+	}
+
+	// 新节点加入时，数据只会迁移到新节点上
+	report, err := consistentHash.AddNode(ctx, "node_d", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.KeyCount() == 0 {
+		t.Fatal("expect migrations after add node")
+	}
+	for _, task := range report.Tasks {
+		if task.To != "node_d" {
+			t.Fatalf("unexpected task: %s -> %s", task.From, task.To)
+		}
+	}
+
+	// 节点退出时，数据只会从该节点迁出
+	if report, err = consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range report.Tasks {
+		if task.From != "node_a" {
+			t.Fatalf("unexpected task: %s -> %s", task.From, task.To)
+		}
+	}
+}
+
+func Test_maglev_hash_placement(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithMaglevHash(1000))
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c", "node_d"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, _ := consistentHash.loadSnapshot(ctx)
+	// 1000 向上取整到质数 1009
+	if len(before.lookup) != 1009 {
+		t.Fatalf("unexpected table size: %d", len(before.lookup))
+	}
+	slots := make(map[int]int)
+	for _, index := range before.lookup {
+		slots[index]++
+	}
+	for index, count := range slots {
+		if count < 1009/4-10 || count > 1009/4+10 {
+			t.Fatalf("node: %s unbalanced slots: %d", before.members[index], count)
+		}
+	}
+
+	for i := 0; i < 500; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := consistentHash.AddNode(ctx, "node_e", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.KeyCount() == 0 {
+		t.Fatal("expect migrations after add node")
+	}
+
+	// 新节点加入后，大部分更换归属的槽位都归属于新节点
+	after, _ := consistentHash.loadSnapshot(ctx)
+	var moved, disrupted int
+	for slot := range after.lookup {
+		owner := after.members[after.lookup[slot]]
+		if owner == before.members[before.lookup[slot]] {
+			continue
+		}
+		moved++
+		if owner != "node_e" {
+			disrupted++
+		}
+	}
+	if moved == 0 || disrupted*10 > moved {
+		t.Fatalf("unexpected disruption, moved: %d, disrupted: %d", moved, disrupted)
+	}
+}
+
+func Test_ketama_placement(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMD5KetamaHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}, WithKetama(), WithReplicas(1))
+
+	weights := map[string]int{"10.0.0.1:11211": 1, "10.0.0.2:11211": 1, "10.0.0.3:11211": 2}
+	for nodeID, weight := range weights {
+		if _, err := consistentHash.AddNode(ctx, nodeID, float64(weight)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot, _ := consistentHash.loadSnapshot(ctx)
+	if len(snapshot.scores) != 0 {
+		t.Fatalf("expect no virtual nodes, got: %d", len(snapshot.scores))
+	}
+	// floor(pct * 40 * 3) 个摘要，每个摘要 4 个点
+	if len(snapshot.continuum) != (30+30+60)*4 {
+		t.Fatalf("unexpected continuum size: %d", len(snapshot.continuum))
+	}
+
+	// 按照 libketama 的算法逐个计算全部的点，与 GetNode 的结果对比
+	type point struct {
+		value  uint32
+		nodeID string
+	}
+	var points []point
+	for nodeID, weight := range weights {
+		for i := 0; i < weight*120/4; i++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", nodeID, i)))
+			for h := 0; h < 4; h++ {
+				value := uint32(digest[3+h*4])<<24 | uint32(digest[2+h*4])<<16 | uint32(digest[1+h*4])<<8 | uint32(digest[h*4])
+				points = append(points, point{value: value, nodeID: nodeID})
+			}
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		digest := md5.Sum([]byte(dataKey))
+		hash := uint32(digest[3])<<24 | uint32(digest[2])<<16 | uint32(digest[1])<<8 | uint32(digest[0])
+
+		var ceiling, first *point
+		for j := range points {
+			if first == nil || points[j].value < first.value {
+				first = &points[j]
+			}
+			if points[j].value >= hash && (ceiling == nil || points[j].value < ceiling.value) {
+				ceiling = &points[j]
+			}
+		}
+		if ceiling == nil {
+			ceiling = first
+		}
+
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node != ceiling.nodeID {
+			t.Fatalf("data key: %s, expect node: %s, got: %s", dataKey, ceiling.nodeID, node)
+		}
+	}
+
+	ownership := snapshot.placement.ownership(snapshot)
+	if share := ownership["10.0.0.3:11211"]; share < 0.35 || share > 0.65 {
+		t.Fatalf("unexpected ownership: %v", ownership)
+	}
+}
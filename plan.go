@@ -0,0 +1,202 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// 应用迁移计划时，哈希环的版本号与计划不一致，说明计划生成之后哈希环已经发生变更
+var ErrPlanStale = errors.New("plan stale")
+
+// 迁移计划对应的节点变更
+type PlanOp string
+
+const (
+	PlanOpAddNode    PlanOp = "add_node"
+	PlanOpRemoveNode PlanOp = "remove_node"
+)
+
+// 节点变更的迁移计划，由 PlanAddNode/PlanRemoveNode 生成，可以在审核后通过 ApplyPlan 执行
+type MigrationPlan struct {
+	Op     PlanOp
+	NodeID string
+	// 添加节点时的权重，以及换算后的虚拟节点个数
+	Weight   float64
+	Replicas int
+	// 生成计划时哈希环的版本号
+	Version int64
+	// 预计的数据迁移任务
+	Tasks []*MigrationTask
+	// 变更前后每个节点作为首个副本所拥有的哈希空间比例
+	OwnershipBefore map[string]float64
+	OwnershipAfter  map[string]float64
+	nodeOpts        NodeOptions
+}
+
+// 预计迁移的数据 key 总数
+func (m *MigrationPlan) KeyCount() int {
+	var keyCount int
+	for _, task := range m.Tasks {
+		keyCount += task.KeyCount
+	}
+	return keyCount
+}
+
+// 推演添加节点的结果，返回完整的迁移计划. 不会修改哈希环，也不会调用 Migrator
+func (c *ConsistentHash) PlanAddNode(ctx context.Context, nodeID string, weight float64, opts ...NodeOption) (*MigrationPlan, error) {
+	var nodeOpts NodeOptions
+	for _, opt := range opts {
+		opt(&nodeOpts)
+	}
+
+	// 加锁只是为了读到一致的哈希环
+	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	before, view, err := c.planBase(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := view.nodes[nodeID]; ok {
+		return nil, errors.New("repeat node")
+	}
+
+	replicas, err := c.getReplicas(view.nodes, nodeID, weight)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualNodes, err := c.placement.join(before, nodeID, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	// 在副本上推演节点变更
+	afterView := view.clone()
+	afterView.nodes[nodeID] = replicas
+	for _, virtualNode := range virtualNodes {
+		afterView.add(virtualNode)
+	}
+
+	plan := MigrationPlan{
+		Op:       PlanOpAddNode,
+		NodeID:   nodeID,
+		Weight:   weight,
+		Replicas: replicas,
+		nodeOpts: nodeOpts,
+	}
+	return c.fillPlan(ctx, &plan, before, afterView)
+}
+
+// 推演删除节点的结果，返回完整的迁移计划. 不会修改哈希环，也不会调用 Migrator
+func (c *ConsistentHash) PlanRemoveNode(ctx context.Context, nodeID string) (*MigrationPlan, error) {
+	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	before, view, err := c.planBase(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas, ok := view.nodes[nodeID]
+	if !ok {
+		return nil, errors.New("invalid node id")
+	}
+
+	virtualNodes, err := c.placement.leave(before, nodeID, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	afterView := view.clone()
+	delete(afterView.nodes, nodeID)
+	for _, virtualNode := range virtualNodes {
+		afterView.rem(virtualNode)
+	}
+
+	plan := MigrationPlan{
+		Op:       PlanOpRemoveNode,
+		NodeID:   nodeID,
+		Replicas: replicas,
+	}
+	return c.fillPlan(ctx, &plan, before, afterView)
+}
+
+// 执行迁移计划. 计划生成之后哈希环发生过变更时拒绝执行，返回 ErrPlanStale.
+// 数据迁移任务基于执行时的数据 key 重新推算，期间新写入的数据 key 同样会被迁移
+func (c *ConsistentHash) ApplyPlan(ctx context.Context, plan *MigrationPlan) (*MigrationReport, error) {
+	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	version, err := c.hashRing.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != plan.Version {
+		return nil, fmt.Errorf("%w, plan version: %d, ring version: %d", ErrPlanStale, plan.Version, version)
+	}
+
+	switch plan.Op {
+	case PlanOpAddNode:
+		return c.addNode(ctx, plan.NodeID, plan.Weight, plan.nodeOpts)
+	case PlanOpRemoveNode:
+		return c.removeNode(ctx, plan.NodeID)
+	default:
+		return nil, fmt.Errorf("invalid plan op: %s", plan.Op)
+	}
+}
+
+// 读取当前的哈希环，作为推演的起点
+func (c *ConsistentHash) planBase(ctx context.Context) (*ringSnapshot, *ringView, error) {
+	version, err := c.hashRing.Version(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	view, err := c.readRingView(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.newRingSnapshot(version, view), view, nil
+}
+
+func (c *ConsistentHash) fillPlan(ctx context.Context, plan *MigrationPlan, before *ringSnapshot, afterView *ringView) (*MigrationPlan, error) {
+	after := c.newRingSnapshot(before.version+1, afterView)
+	plan.Version = before.version
+	if c.opts.rangeMigrator != nil {
+		var err error
+		if plan.Tasks, err = c.planRangeMigration(before, after); err != nil {
+			return nil, err
+		}
+	}
+
+	// 与 migrate 保持一致，没有注入迁移函数或者没有记录数据 key 时，ApplyPlan 不会迁移任何数据 key
+	if c.migrator != nil && c.dataKeyIndex != nil {
+		datas, err := c.planMigration(ctx, before, after)
+		if err != nil {
+			return nil, err
+		}
+		plan.Tasks = append(plan.Tasks, newMigrationTasks(datas)...)
+	}
+	plan.OwnershipBefore = c.placement.ownership(before)
+	plan.OwnershipAfter = c.placement.ownership(after)
+	return plan, nil
+}
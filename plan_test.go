@@ -0,0 +1,120 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_plan_and_apply(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	var migrations int64
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		atomic.AddInt64(&migrations, 1)
+		return nil
+	})
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := dumpRingState(t, hashRing)
+	plan, err := consistentHash.PlanAddNode(ctx, "node_d", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, dumpRingState(t, hashRing)) || atomic.LoadInt64(&migrations) != 0 {
+		t.Fatal("plan should not mutate the ring or call the migrator")
+	}
+	if plan.KeyCount() == 0 || plan.Replicas != 10 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	for _, ownership := range []map[string]float64{plan.OwnershipBefore, plan.OwnershipAfter} {
+		var total float64
+		for _, share := range ownership {
+			total += share
+		}
+		if math.Abs(total-1) > 1e-9 {
+			t.Fatalf("expect ownership sum to 1, got: %v", ownership)
+		}
+	}
+	if _, ok := plan.OwnershipBefore["node_d"]; ok || plan.OwnershipAfter["node_d"] == 0 {
+		t.Fatalf("unexpected ownership: %v -> %v", plan.OwnershipBefore, plan.OwnershipAfter)
+	}
+
+	// 执行计划得到的迁移任务与计划一致
+	report, err := consistentHash.ApplyPlan(ctx, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tasks) != len(plan.Tasks) {
+		t.Fatalf("expect %d tasks, got %d", len(plan.Tasks), len(report.Tasks))
+	}
+	for i, task := range report.Tasks {
+		if task.From != plan.Tasks[i].From || task.To != plan.Tasks[i].To || !reflect.DeepEqual(task.DataKeys, plan.Tasks[i].DataKeys) {
+			t.Fatalf("task: %d expect: %+v, got: %+v", i, plan.Tasks[i], task)
+		}
+	}
+
+	// 计划生成之后哈希环发生了变更，拒绝执行
+	plan, err = consistentHash.PlanRemoveNode(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plan.OwnershipAfter["node_a"]; ok {
+		t.Fatalf("unexpected ownership: %v", plan.OwnershipAfter)
+	}
+	if _, err = consistentHash.AddNode(ctx, "node_e", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = consistentHash.ApplyPlan(ctx, plan); !errors.Is(err, ErrPlanStale) {
+		t.Fatalf("expect plan stale, got: %v", err)
+	}
+}
+
+func Test_plan_without_migrator(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), nil)
+	for _, nodeID := range []string{"node_a", "node_b"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 没有注入迁移函数时，ApplyPlan 不会迁移数据，计划中同样不应包含迁移任务
+	plan, err := consistentHash.PlanAddNode(ctx, "node_c", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Tasks) != 0 || plan.KeyCount() != 0 {
+		t.Fatalf("expect no migration tasks, got: %d tasks, %d keys", len(plan.Tasks), plan.KeyCount())
+	}
+
+	report, err := consistentHash.ApplyPlan(ctx, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.KeyCount() != plan.KeyCount() {
+		t.Fatalf("expect %d keys migrated, got: %d", plan.KeyCount(), report.KeyCount())
+	}
+}
@@ -0,0 +1,125 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// 基于 score 区间的迁移函数，适用于能够按照区间迁移数据、但不记录单个数据 key 的存储引擎.
+// ranges 内的数据 key 的首个副本由 from 节点变为 to 节点
+type RangeMigrator func(ctx context.Context, ranges []ScoreRange, from, to string) error
+
+// 放置策略无法按照 score 区间描述数据的归属，例如 jump、rendezvous、maglev 以及 ketama
+var ErrRangesUnsupported = errors.New("ranges unsupported by placement")
+
+// 指定了 RangeMigrator 时，放置策略必须支持按照 score 区间描述数据归属. 有界负载模式下数据的去向取决于各节点的负载，同样不支持
+func (c *ConsistentHash) checkRangeMigrator() error {
+	if c.opts.rangeMigrator == nil {
+		return nil
+	}
+	if _, ok := c.placement.(arcPlacement); !ok {
+		return fmt.Errorf("%w, range migrator cannot be used with placement: %s", ErrRangesUnsupported, c.placement.name())
+	}
+	if c.opts.boundedLoads {
+		return fmt.Errorf("%w, range migrator cannot be used with bounded loads", ErrRangesUnsupported)
+	}
+	return nil
+}
+
+// 返回节点作为首个副本所拥有的 score 区间，区间按照 Start 升序排列. 数据 key 的 score 由 Encryptor 计算.
+// 区间只反映哈希环的拓扑，不考虑节点的状态
+func (c *ConsistentHash) Ranges(ctx context.Context, nodeID string) ([]ScoreRange, error) {
+	arcPlacement, ok := c.placement.(arcPlacement)
+	if !ok {
+		return nil, ErrRangesUnsupported
+	}
+
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok = snapshot.weights[nodeID]; !ok {
+		return nil, errors.New("invalid node id")
+	}
+	return arcPlacement.ranges(snapshot, nodeID), nil
+}
+
+// 每个虚拟节点拥有 (前一个虚拟节点, 当前虚拟节点] 之间的弧，score 碰撞时由排序后的首个节点拥有
+func (r *ringPlacement) ranges(snapshot *ringSnapshot, nodeID string) []ScoreRange {
+	var ranges []ScoreRange
+	for i, score := range snapshot.scores {
+		if snapshot.nodes[i][0] != nodeID {
+			continue
+		}
+		prev := snapshot.scores[(i-1+len(snapshot.scores))%len(snapshot.scores)]
+		ranges = append(ranges, splitArc(prev, score)...)
+	}
+	return mergeScoreRanges(ranges)
+}
+
+// 以变更前后全部虚拟节点的 score 将环切分为若干段，同一段内的数据 key 在变更前后分别拥有相同的首个副本
+func (r *ringPlacement) movedRanges(before, after *ringSnapshot) map[migrateRoute][]ScoreRange {
+	moved := make(map[migrateRoute][]ScoreRange)
+	// 变更前没有节点则没有数据，变更后没有节点则无处迁移
+	if len(before.scores) == 0 || len(after.scores) == 0 {
+		return moved
+	}
+
+	scores := make([]int64, 0, len(before.scores)+len(after.scores))
+	scores = append(scores, before.scores...)
+	scores = append(scores, after.scores...)
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i] < scores[j]
+	})
+	bounds := scores[:0]
+	for i, score := range scores {
+		if i == 0 || score != scores[i-1] {
+			bounds = append(bounds, score)
+		}
+	}
+
+	for i, score := range bounds {
+		from := before.nodes[before.ceiling(score)][0]
+		to := after.nodes[after.ceiling(score)][0]
+		if from == to {
+			continue
+		}
+		route := migrateRoute{from: from, to: to}
+		prev := bounds[(i-1+len(bounds))%len(bounds)]
+		moved[route] = append(moved[route], splitArc(prev, score)...)
+	}
+
+	for route, ranges := range moved {
+		moved[route] = mergeScoreRanges(ranges)
+	}
+	return moved
+}
+
+// 对比节点变更前后的哈希环，为首个副本发生变化的 score 区间创建迁移任务
+func (c *ConsistentHash) planRangeMigration(before, after *ringSnapshot) ([]*MigrationTask, error) {
+	arcPlacement, ok := c.placement.(arcPlacement)
+	if !ok {
+		return nil, ErrRangesUnsupported
+	}
+
+	moved := arcPlacement.movedRanges(before, after)
+	migrateTasks := make([]*MigrationTask, 0, len(moved))
+	for route, ranges := range moved {
+		migrateTasks = append(migrateTasks, &MigrationTask{
+			From:   route.from,
+			To:     route.to,
+			Ranges: ranges,
+		})
+	}
+
+	sort.Slice(migrateTasks, func(i, j int) bool {
+		if migrateTasks[i].From != migrateTasks[j].From {
+			return migrateTasks[i].From < migrateTasks[j].From
+		}
+		return migrateTasks[i].To < migrateTasks[j].To
+	})
+	return migrateTasks, nil
+}
@@ -0,0 +1,149 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_ranges(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mutex sync.Mutex
+		moved = make(map[migrateRoute][]ScoreRange)
+	)
+	rangeMigrator := func(ctx context.Context, ranges []ScoreRange, from, to string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		route := migrateRoute{from: from, to: to}
+		moved[route] = append(moved[route], ranges...)
+		return nil
+	}
+
+	// 不记录数据 key，只依赖区间迁移
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), nil,
+		WithReplicas(10), WithDataKeyTracking(DataKeyTrackingNone), WithRangeMigrator(rangeMigrator))
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 各节点的区间互不重叠，并且覆盖整个哈希空间
+	var all []ScoreRange
+	owners := make(map[string][]ScoreRange)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		ranges, err := consistentHash.Ranges(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[nodeID] = ranges
+		all = append(all, ranges...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Start < all[j].Start
+	})
+	if all[0].Start != math.MinInt64 || all[len(all)-1].End != math.MaxInt64 {
+		t.Fatalf("ranges do not cover the hash space: %v", all)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Start != all[i-1].End {
+			t.Fatalf("ranges overlap or leave a gap: %v, %v", all[i-1], all[i])
+		}
+	}
+
+	before := make(map[string]string)
+	for i := 0; i < 500; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		node, err := consistentHash.Locate(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !inRanges(owners[node], consistentHash.hash(dataKey)) {
+			t.Fatalf("data key: %s not in ranges of owner: %s", dataKey, node)
+		}
+		before[dataKey] = node
+	}
+
+	// 首个副本发生变化的数据 key 恰好落在对应迁移任务的区间内
+	moved = make(map[migrateRoute][]ScoreRange)
+	report, err := consistentHash.AddNode(ctx, "node_d", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tasks) == 0 {
+		t.Fatal("expect range migration tasks")
+	}
+	for dataKey, from := range before {
+		to, err := consistentHash.Locate(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		score := consistentHash.hash(dataKey)
+		for route, ranges := range moved {
+			if expect := route.from == from && route.to == to; inRanges(ranges, score) != expect {
+				t.Fatalf("data key: %s, from: %s, to: %s, route: %+v, in ranges: %v", dataKey, from, to, route, !expect)
+			}
+		}
+	}
+
+	if _, err = consistentHash.Ranges(ctx, "node_x"); err == nil {
+		t.Fatal("expect invalid node id")
+	}
+
+	consistentHash = NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), nil, WithJumpHash())
+	if _, err = consistentHash.Ranges(ctx, "node_a"); !errors.Is(err, ErrRangesUnsupported) {
+		t.Fatalf("expect ranges unsupported, got: %v", err)
+	}
+}
+
+func Test_range_migrator_unsupported_placement(t *testing.T) {
+	rangeMigrator := func(ctx context.Context, ranges []ScoreRange, from, to string) error {
+		return nil
+	}
+
+	newConsistentHash := func(opts ...ConsistentHashOption) error {
+		_, err := NewConsistentHashE(local.NewSkiplistHashRing(), NewMurmurHasher(), nil, append(opts, WithRangeMigrator(rangeMigrator))...)
+		return err
+	}
+
+	// 不检查配置的构造函数在变更哈希环时返回同样的错误，并且不会修改哈希环
+	addNode := func(opts ...ConsistentHashOption) error {
+		hashRing := local.NewSkiplistHashRing()
+		consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, append(opts, WithRangeMigrator(rangeMigrator))...)
+		_, err := consistentHash.AddNode(context.Background(), "node_a", 1)
+		if nodes, _ := hashRing.Nodes(context.Background()); err != nil && len(nodes) != 0 {
+			t.Fatalf("expect no nodes, got: %v", nodes)
+		}
+		return err
+	}
+
+	unsupported := map[string]ConsistentHashOption{
+		"jump":       WithJumpHash(),
+		"rendezvous": WithRendezvousHash(),
+		"maglev":     WithMaglevHash(0),
+		"ketama":     WithKetama(),
+		"bounded":    WithBoundedLoads(0.25),
+	}
+	for name, opt := range unsupported {
+		if err := newConsistentHash(opt); !errors.Is(err, ErrRangesUnsupported) {
+			t.Fatalf("placement: %s, expect ranges unsupported, got: %v", name, err)
+		}
+		if err := addNode(opt); !errors.Is(err, ErrRangesUnsupported) {
+			t.Fatalf("placement: %s, expect add node to fail with ranges unsupported, got: %v", name, err)
+		}
+	}
+
+	if err := newConsistentHash(WithReplicas(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := addNode(WithReplicas(10)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,347 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 基于 redis 的数据 key 索引，每个节点下的数据 key 存放在一个 hash 中，field 为数据 key，
+// value 为过期时间（unix 毫秒），0 代表永不过期. 同时在一个 zset 中按照 score 排列数据 key，
+// 未记录 score 的数据 key 的 score 为 -inf. key 中包含哈希环的 key，不同哈希环下同名的节点互不影响
+type DataKeyIndex struct {
+	key         string
+	redisClient *Client
+}
+
+// key 通常与所属哈希环的 key 保持一致
+func NewDataKeyIndex(key string, redisClient *Client) *DataKeyIndex {
+	return &DataKeyIndex{
+		key:         key,
+		redisClient: redisClient,
+	}
+}
+
+// 旧版本中以 json 字符串整体存放节点数据 key 的 key
+func (d *DataKeyIndex) getLegacyNodeDataKey(nodeID string) string {
+	return fmt.Sprintf("redis:consistent_hash:ring:node:data:%s", nodeID)
+}
+
+// 早期版本中不区分哈希环的数据 key 的 hash 与 zset
+func (d *DataKeyIndex) getUnscopedNodeDataKey(nodeID string) string {
+	return fmt.Sprintf("redis:consistent_hash:ring:node:datakeys:%s", nodeID)
+}
+
+func (d *DataKeyIndex) getUnscopedNodeScoreKey(nodeID string) string {
+	return fmt.Sprintf("redis:consistent_hash:ring:node:datakeyscores:%s", nodeID)
+}
+
+func (d *DataKeyIndex) getNodeDataKey(nodeID string) string {
+	return fmt.Sprintf("redis:consistent_hash:ring:node:datakeys:%s:%s", d.key, nodeID)
+}
+
+func (d *DataKeyIndex) getNodeScoreKey(nodeID string) string {
+	return fmt.Sprintf("redis:consistent_hash:ring:node:datakeyscores:%s:%s", d.key, nodeID)
+}
+
+const (
+	// 写入数据 key，已经存在的数据 key 保留原有的过期时间与 score
+	luaAddDataKeys = `
+for i = 1, #ARGV do
+	redis.call('HSETNX', KEYS[1], ARGV[i], '0')
+	redis.call('ZADD', KEYS[2], 'NX', '-inf', ARGV[i])
+end
+return 1
+`
+	// 写入数据 key 及其 score，ARGV 依次为数据 key 与 score
+	luaAddScoredDataKeys = `
+for i = 1, #ARGV, 2 do
+	redis.call('HSETNX', KEYS[1], ARGV[i], '0')
+	redis.call('ZADD', KEYS[2], ARGV[i + 1], ARGV[i])
+end
+return 1
+`
+	luaDeleteDataKeys = `
+for i = 1, #ARGV do
+	redis.call('HDEL', KEYS[1], ARGV[i])
+	redis.call('ZREM', KEYS[2], ARGV[i])
+end
+return 1
+`
+	// 将 KEYS[1]、KEYS[2] 中的数据 key 合并到 KEYS[3]、KEYS[4] 中，已经存在的数据 key 保持不变，合并后删除旧的 key
+	luaMergeDataKeys = `
+local fields = redis.call('HGETALL', KEYS[1])
+for i = 1, #fields, 2 do
+	redis.call('HSETNX', KEYS[3], fields[i], fields[i + 1])
+end
+local members = redis.call('ZRANGE', KEYS[2], 0, -1, 'WITHSCORES')
+for i = 1, #members, 2 do
+	redis.call('ZADD', KEYS[4], 'NX', members[i + 1], members[i])
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+return 1
+`
+	// 只为已经存在的数据 key 设置过期时间
+	luaExpireDataKeys = `
+for i = 2, #ARGV do
+	if redis.call('HEXISTS', KEYS[1], ARGV[i]) == 1 then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[1])
+	end
+end
+return 1
+`
+)
+
+func expired(rawExpireAt string, now int64) bool {
+	expireAt, _ := strconv.ParseInt(rawExpireAt, 10, 64)
+	return expireAt > 0 && expireAt <= now
+}
+
+// 读取节点下未过期的数据 key，并顺带清理已经过期的数据 key
+func (d *DataKeyIndex) liveDataKeys(ctx context.Context, nodeID string) (map[string]string, error) {
+	fields, err := d.redisClient.HGetAll(ctx, d.getNodeDataKey(nodeID))
+	if err != nil {
+		return nil, fmt.Errorf("redis data key index dataKeys hgetall failed, err: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+	var expiredKeys []string
+	for dataKey, rawExpireAt := range fields {
+		if expired(rawExpireAt, now) {
+			expiredKeys = append(expiredKeys, dataKey)
+			delete(fields, dataKey)
+		}
+	}
+	// 清理失败不影响本次读取的结果，下次读取时会再次尝试
+	_ = d.deleteDataKeys(ctx, nodeID, expiredKeys)
+	return fields, nil
+}
+
+func (d *DataKeyIndex) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	fields, err := d.liveDataKeys(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKeys := make(map[string]struct{}, len(fields))
+	for dataKey := range fields {
+		dataKeys[dataKey] = struct{}{}
+	}
+	return dataKeys, nil
+}
+
+func (d *DataKeyIndex) DataKeysCount(ctx context.Context, nodeID string) (int, error) {
+	fields, err := d.liveDataKeys(ctx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	return len(fields), nil
+}
+
+func (d *DataKeyIndex) HasDataKey(ctx context.Context, nodeID, dataKey string) (bool, error) {
+	rawExpireAt, err := d.redisClient.HGet(ctx, d.getNodeDataKey(nodeID), dataKey)
+	if errors.Is(err, redis.ErrNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("redis data key index hasDataKey hget failed, err: %w", err)
+	}
+	return !expired(rawExpireAt, time.Now().UnixMilli()), nil
+}
+
+// 写入数据 key，已经存在的数据 key 保留原有的过期时间
+func (d *DataKeyIndex) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+
+	keys := make([]interface{}, 0, 2+len(dataKeys))
+	keys = append(keys, d.getNodeDataKey(nodeID), d.getNodeScoreKey(nodeID))
+	for dataKey := range dataKeys {
+		keys = append(keys, dataKey)
+	}
+	if _, err := d.redisClient.Eval(ctx, luaAddDataKeys, 2, keys); err != nil {
+		return fmt.Errorf("redis data key index addNodeToDataKey failed, err: %w", err)
+	}
+	return nil
+}
+
+// 写入数据 key 及其 score，已经存在的数据 key 保留原有的过期时间
+func (d *DataKeyIndex) AddNodeToScoredDataKeys(ctx context.Context, nodeID string, dataKeys map[string]int64) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+
+	keys := make([]interface{}, 0, 2+len(dataKeys)<<1)
+	keys = append(keys, d.getNodeDataKey(nodeID), d.getNodeScoreKey(nodeID))
+	for dataKey, score := range dataKeys {
+		keys = append(keys, dataKey, score)
+	}
+	if _, err := d.redisClient.Eval(ctx, luaAddScoredDataKeys, 2, keys); err != nil {
+		return fmt.Errorf("redis data key index addNodeToScoredDataKeys failed, err: %w", err)
+	}
+	return nil
+}
+
+func (d *DataKeyIndex) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	keys := make([]string, 0, len(dataKeys))
+	for dataKey := range dataKeys {
+		keys = append(keys, dataKey)
+	}
+	if err := d.deleteDataKeys(ctx, nodeID, keys); err != nil {
+		return fmt.Errorf("redis data key index deleteNodeToDataKeys failed, err: %w", err)
+	}
+	return nil
+}
+
+func (d *DataKeyIndex) deleteDataKeys(ctx context.Context, nodeID string, dataKeys []string) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+
+	keys := make([]interface{}, 0, 2+len(dataKeys))
+	keys = append(keys, d.getNodeDataKey(nodeID), d.getNodeScoreKey(nodeID))
+	for _, dataKey := range dataKeys {
+		keys = append(keys, dataKey)
+	}
+	_, err := d.redisClient.Eval(ctx, luaDeleteDataKeys, 2, keys)
+	return err
+}
+
+// 返回 score 位于 (start, end] 之间的数据 key，未记录 score 的数据 key 总是包含在结果中.
+// zset 的 score 为双精度浮点数，超过 2^53 的 score 存在精度损失，因此按照闭区间查询，结果可能包含少量区间边界上的数据 key
+func (d *DataKeyIndex) RangeDataKeys(ctx context.Context, nodeID string, start, end int64) (map[string]struct{}, error) {
+	unscoredKeys, err := d.redisClient.ZRangeByScoreMembers(ctx, d.getNodeScoreKey(nodeID), "-inf", "-inf")
+	if err != nil {
+		return nil, fmt.Errorf("redis data key index rangeDataKeys failed, err: %w", err)
+	}
+
+	min := strconv.FormatFloat(float64(start), 'g', -1, 64)
+	max := strconv.FormatFloat(float64(end), 'g', -1, 64)
+	scoredKeys, err := d.redisClient.ZRangeByScoreMembers(ctx, d.getNodeScoreKey(nodeID), min, max)
+	if err != nil {
+		return nil, fmt.Errorf("redis data key index rangeDataKeys failed, err: %w", err)
+	}
+
+	keys := append(unscoredKeys, scoredKeys...)
+	rawExpireAts, err := d.redisClient.HMGet(ctx, d.getNodeDataKey(nodeID), keys...)
+	if err != nil {
+		return nil, fmt.Errorf("redis data key index rangeDataKeys hmget failed, err: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+	dataKeys := make(map[string]struct{}, len(keys))
+	for i, rawExpireAt := range rawExpireAts {
+		// hash 中不存在的数据 key 已经被删除
+		if rawExpireAt == "" || expired(rawExpireAt, now) {
+			continue
+		}
+		dataKeys[keys[i]] = struct{}{}
+	}
+	return dataKeys, nil
+}
+
+func (d *DataKeyIndex) ExpireDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}, expireAt int64) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+
+	keys := make([]interface{}, 0, 2+len(dataKeys))
+	keys = append(keys, d.getNodeDataKey(nodeID), expireAt)
+	for dataKey := range dataKeys {
+		keys = append(keys, dataKey)
+	}
+	if _, err := d.redisClient.Eval(ctx, luaExpireDataKeys, 1, keys); err != nil {
+		return fmt.Errorf("redis data key index expireDataKeys failed, err: %w", err)
+	}
+	return nil
+}
+
+func (d *DataKeyIndex) DataKeysExpireAt(ctx context.Context, nodeID string, dataKeys map[string]struct{}) (map[string]int64, error) {
+	keys := make([]string, 0, len(dataKeys))
+	for dataKey := range dataKeys {
+		keys = append(keys, dataKey)
+	}
+
+	rawExpireAts, err := d.redisClient.HMGet(ctx, d.getNodeDataKey(nodeID), keys...)
+	if err != nil {
+		return nil, fmt.Errorf("redis data key index dataKeysExpireAt hmget failed, err: %w", err)
+	}
+
+	expireAts := make(map[string]int64)
+	for i, rawExpireAt := range rawExpireAts {
+		if expireAt, _ := strconv.ParseInt(rawExpireAt, 10, 64); expireAt > 0 {
+			expireAts[keys[i]] = expireAt
+		}
+	}
+	return expireAts, nil
+}
+
+// 基于 hscan 分页遍历，count 只是对 redis 的提示，单页返回的数量可能多于或少于 count，
+// 遍历期间发生 rehash 时同一个数据 key 可能被返回多次
+func (d *DataKeyIndex) ScanDataKeys(ctx context.Context, nodeID, cursor string, count int) ([]string, string, error) {
+	if cursor == "" {
+		cursor = "0"
+	}
+
+	fields, next, err := d.redisClient.HScan(ctx, d.getNodeDataKey(nodeID), cursor, count)
+	if err != nil {
+		return nil, "", fmt.Errorf("redis data key index scanDataKeys failed, err: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+	dataKeys := make([]string, 0, len(fields))
+	for dataKey, rawExpireAt := range fields {
+		if !expired(rawExpireAt, now) {
+			dataKeys = append(dataKeys, dataKey)
+		}
+	}
+
+	if next == "0" {
+		next = ""
+	}
+	return dataKeys, next, nil
+}
+
+// 节点下是否存在旧版本的数据 key
+func (d *DataKeyIndex) hasLegacyDataKeys(ctx context.Context, nodeID string) (bool, error) {
+	legacy, err := d.redisClient.Exists(ctx, d.getLegacyNodeDataKey(nodeID), d.getUnscopedNodeDataKey(nodeID), d.getUnscopedNodeScoreKey(nodeID))
+	if err != nil {
+		return false, fmt.Errorf("redis data key index legacy exists failed, err: %w", err)
+	}
+	return legacy, nil
+}
+
+// 将旧版本以 json 字符串存放的数据 key，以及早期版本中不区分哈希环的数据 key 迁移到当前的 key 中，迁移后删除旧的 key.
+// 早期版本的 key 只包含 nodeID，多个哈希环存在同名节点时，由首个执行升级的哈希环接管
+func (d *DataKeyIndex) UpgradeLegacyDataKeys(ctx context.Context, nodeID string) error {
+	keys := []interface{}{d.getUnscopedNodeDataKey(nodeID), d.getUnscopedNodeScoreKey(nodeID), d.getNodeDataKey(nodeID), d.getNodeScoreKey(nodeID)}
+	if _, err := d.redisClient.Eval(ctx, luaMergeDataKeys, 4, keys); err != nil {
+		return fmt.Errorf("redis data key index upgrade merge failed, err: %w", err)
+	}
+
+	resStr, err := d.redisClient.Get(ctx, d.getLegacyNodeDataKey(nodeID))
+	if errors.Is(err, redis.ErrNil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("redis data key index upgrade get failed, err: %w", err)
+	}
+
+	var dataKeys map[string]struct{}
+	if len(resStr) > 0 {
+		if err = json.Unmarshal([]byte(resStr), &dataKeys); err != nil {
+			return err
+		}
+	}
+
+	if err = d.AddNodeToDataKeys(ctx, nodeID, dataKeys); err != nil {
+		return err
+	}
+	return d.redisClient.Del(ctx, d.getLegacyNodeDataKey(nodeID))
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/demdxx/gocast"
 	"github.com/gomodule/redigo/redis"
@@ -12,14 +16,22 @@ import (
 )
 
 type RedisHashRing struct {
+	// 哈希环同时实现了数据 key 的索引
+	*DataKeyIndex
 	key         string
 	redisClient *Client
+	// 已经确认不存在旧版本的存储结构，置为 1 后不再检查
+	upgraded int32
+	// 最近一次成功读取的版本号，尚未读取过时为 -1
+	lastVersion int64
 }
 
 func NewRedisHashRing(key string, redisClient *Client) *RedisHashRing {
 	return &RedisHashRing{
-		key:         key,
-		redisClient: redisClient,
+		DataKeyIndex: NewDataKeyIndex(key, redisClient),
+		key:          key,
+		redisClient:  redisClient,
+		lastVersion:  -1,
 	}
 }
 
@@ -27,23 +39,72 @@ func (r *RedisHashRing) getLockKey() string {
 	return fmt.Sprintf("redis:consistent_hash:ring:lock:%s", r.key)
 }
 
+// 旧版本以 zset 分值存储 32 位 score 的虚拟节点表，仅用于 UpgradeLegacyTable
 func (r *RedisHashRing) getTableKey() string {
 	return fmt.Sprintf("redis:consistent_hash:ring:%s", r.key)
 }
 
+// 存放虚拟节点 score 的 zset，按照字典序排列
+func (r *RedisHashRing) getScoreIndexKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:score:%s", r.key)
+}
+
+// 存放 score 对应节点列表的 hash
+func (r *RedisHashRing) getVirtualNodeKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:vnode:%s", r.key)
+}
+
 func (r *RedisHashRing) getNodeReplicaKey() string {
 	return fmt.Sprintf("redis:consistent_hash:ring:node:replica:%s", r.key)
 }
 
-func (r *RedisHashRing) getNodeDataKey(nodeID string) string {
-	return fmt.Sprintf("redis:consistent_hash:ring:node:data:%s", nodeID)
+func (r *RedisHashRing) getNodeMetaKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:node:meta:%s", r.key)
+}
+
+func (r *RedisHashRing) getNodeStateKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:node:state:%s", r.key)
+}
+
+// 存储结构已经升级的标记. 标记存在时无需再检查旧版本的存储结构
+func (r *RedisHashRing) getLayoutKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:layout:%s", r.key)
+}
+
+func (r *RedisHashRing) getVersionKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:version:%s", r.key)
+}
+
+func (r *RedisHashRing) getRingConfigKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:config:%s", r.key)
 }
 
-// 锁住哈希环，支持配置过期时间. 达到过期时间后，会自动释放锁
+func (r *RedisHashRing) getDataKeyReplicasKey() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:datakeyreplicas:%s", r.key)
+}
+
+func (r *RedisHashRing) getMigrationJobKey(jobID string) string {
+	return fmt.Sprintf("redis:consistent_hash:ring:migration:job:%s:%s", r.key, jobID)
+}
+
+func (r *RedisHashRing) getEventChannel() string {
+	return fmt.Sprintf("redis:consistent_hash:ring:event:%s", r.key)
+}
+
+// 锁住哈希环，支持配置过期时间. 达到过期时间后，会自动释放锁.
+// 加锁成功后，倘若哈希环仍是旧版本的存储结构，则在锁的保护下完成升级
 func (r *RedisHashRing) Lock(ctx context.Context, expireSeconds int) error {
 
 	lock := redis_lock.NewRedisLock(r.getLockKey(), r.redisClient, redis_lock.WithExpireSeconds(int64(expireSeconds)))
-	return lock.Lock(ctx)
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+
+	if err := r.upgradeLegacy(ctx); err != nil {
+		_ = lock.Unlock(ctx)
+		return err
+	}
+	return nil
 }
 
 func (r *RedisHashRing) Unlock(ctx context.Context) error {
@@ -51,124 +112,284 @@ func (r *RedisHashRing) Unlock(ctx context.Context) error {
 	return lock.Unlock(ctx)
 }
 
-func (r *RedisHashRing) Add(ctx context.Context, score int32, nodeID string) error {
-	// add 操作本质上是要在 score 中追加一个 nodeID
-	scoreEntities, err := r.redisClient.ZRangeByScore(ctx, r.getTableKey(), int64(score), int64(score))
+// zset 的 score 是双精度浮点数，无法精确表示 64 位的 score. 因此虚拟节点的 score 编码为定长的字符串，
+// 以相同的分值写入 zset，借助字典序完成 ceiling/floor 查询. score 对应的节点列表存放在 hash 中
+func encodeScore(score int64) string {
+	return fmt.Sprintf("%016x", uint64(score)^1<<63)
+}
+
+func decodeScore(member string) (int64, error) {
+	raw, err := strconv.ParseUint(member, 16, 64)
 	if err != nil {
-		return fmt.Errorf("redis ring add failed, err: %w", err)
+		return 0, fmt.Errorf("invalid score member: %s, err: %w", member, err)
 	}
+	return int64(raw ^ 1<<63), nil
+}
+
+// score 下的一个虚拟节点，以 (nodeID, index) 的形式存储
+type virtualNodeEntry struct {
+	NodeID string `json:"node_id"`
+	Index  int    `json:"index"`
+}
 
-	if len(scoreEntities) > 1 {
-		return fmt.Errorf("invalid score entity len: %d", len(scoreEntities))
+// 查询 score 下的虚拟节点列表，score 不存在时返回空列表
+func (r *RedisHashRing) scoreEntries(ctx context.Context, member string) ([]virtualNodeEntry, error) {
+	rawEntries, err := r.redisClient.HGet(ctx, r.getVirtualNodeKey(), member)
+	if errors.Is(err, redis.ErrNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// 所以需要先查出来 score 对应的 val，append nodeID，再设置回去
-	var nodeIDs []string
-	if len(scoreEntities) == 1 {
-		if err = json.Unmarshal([]byte(scoreEntities[0].Val), &nodeIDs); err != nil {
-			return err
-		}
-		for _, _nodeID := range nodeIDs {
-			if _nodeID == nodeID {
-				return nil
-			}
-		}
+	var entries []virtualNodeEntry
+	if err = json.Unmarshal([]byte(rawEntries), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
 
-		if err = r.redisClient.ZRem(ctx, r.getTableKey(), scoreEntities[0].Score); err != nil {
-			return fmt.Errorf("redis ring zrem failed, err: %w", err)
+func (r *RedisHashRing) Add(ctx context.Context, score int64, nodeID string, index int) error {
+	// add 操作本质上是要在 score 中追加一个虚拟节点
+	member := encodeScore(score)
+	entries, err := r.scoreEntries(ctx, member)
+	if err != nil {
+		return fmt.Errorf("redis ring add failed, err: %w", err)
+	}
+
+	// 所以需要先查出来 score 对应的 val，append 虚拟节点，再设置回去
+	entry := virtualNodeEntry{NodeID: nodeID, Index: index}
+	for _, _entry := range entries {
+		if _entry == entry {
+			return nil
 		}
 	}
 
-	nodeIDs = append(nodeIDs, nodeID)
-	newNodeIDs, _ := json.Marshal(nodeIDs)
-	if err = r.redisClient.ZAdd(ctx, r.getTableKey(), int64(score), string(newNodeIDs)); err != nil {
+	entries = append(entries, entry)
+	newEntries, _ := json.Marshal(entries)
+	if err = r.redisClient.HSet(ctx, r.getVirtualNodeKey(), member, string(newEntries)); err != nil {
+		return fmt.Errorf("redis ring hset failed, err: %w", err)
+	}
+	if err = r.redisClient.ZAdd(ctx, r.getScoreIndexKey(), 0, member); err != nil {
 		return fmt.Errorf("redis ring zadd failed, err: %w", err)
 	}
 	return nil
 }
 
-func (r *RedisHashRing) Ceiling(ctx context.Context, score int32) (int32, error) {
-	scoreEntity, err := r.redisClient.Ceiling(ctx, r.getTableKey(), int64(score))
-	if err != nil && !errors.Is(err, ErrScoreNotExist) {
+func (r *RedisHashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	members, err := r.redisClient.ZRangeByLex(ctx, r.getScoreIndexKey(), "["+encodeScore(score), "+", 1)
+	if err != nil {
 		return 0, fmt.Errorf("redis ring ceiling failed, err: %w", err)
 	}
 
-	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
+	if len(members) == 0 {
+		if members, err = r.redisClient.ZRangeByLex(ctx, r.getScoreIndexKey(), "-", "+", 1); err != nil {
+			return 0, fmt.Errorf("redis ring first failed, err: %w", err)
+		}
+	}
+
+	if len(members) == 0 {
+		return -1, nil
 	}
+	return decodeScore(members[0])
+}
 
-	if scoreEntity, err = r.redisClient.FirstOrLast(ctx, r.getTableKey(), true); err != nil && !errors.Is(err, ErrScoreNotExist) {
-		return 0, fmt.Errorf("redis ring first failed, err: %w", err)
+func (r *RedisHashRing) Floor(ctx context.Context, score int64) (int64, error) {
+	members, err := r.redisClient.ZRevRangeByLex(ctx, r.getScoreIndexKey(), "["+encodeScore(score), "-", 1)
+	if err != nil {
+		return 0, fmt.Errorf("redis ring floor failed, err: %w", err)
 	}
 
-	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
+	if len(members) == 0 {
+		if members, err = r.redisClient.ZRevRangeByLex(ctx, r.getScoreIndexKey(), "+", "-", 1); err != nil {
+			return 0, fmt.Errorf("redis ring last failed, err: %w", err)
+		}
 	}
 
-	return -1, nil
+	if len(members) == 0 {
+		return -1, nil
+	}
+	return decodeScore(members[0])
 }
 
-func (r *RedisHashRing) Floor(ctx context.Context, score int32) (int32, error) {
-	scoreEntity, err := r.redisClient.Floor(ctx, r.getTableKey(), int64(score))
-	if err != nil && !errors.Is(err, ErrScoreNotExist) {
-		return 0, fmt.Errorf("redis ring floor failed, err: %w", err)
+func (r *RedisHashRing) Rem(ctx context.Context, score int64, nodeID string, index int) error {
+	// rem 操作本质上是要在 score 中删去一个虚拟节点
+	member := encodeScore(score)
+	entries, err := r.scoreEntries(ctx, member)
+	if err != nil {
+		return fmt.Errorf("redis ring rem hget failed, err: %w", err)
 	}
 
-	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
+	if len(entries) == 0 {
+		return fmt.Errorf("redis ring rem failed, score not exist: %d", score)
+	}
+
+	pos := -1
+	for i := 0; i < len(entries); i++ {
+		if entries[i] == (virtualNodeEntry{NodeID: nodeID, Index: index}) {
+			pos = i
+			break
+		}
 	}
 
-	if scoreEntity, err = r.redisClient.FirstOrLast(ctx, r.getTableKey(), false); err != nil && !errors.Is(err, ErrScoreNotExist) {
-		return 0, fmt.Errorf("redis ring last failed, err: %w", err)
+	// 与 local 的实现保持一致，删除不存在的虚拟节点时返回错误，回滚依赖这一点判断写入是否生效
+	if pos == -1 {
+		return fmt.Errorf("redis ring rem failed, node: %s, index: %d not exist in score: %d", nodeID, index, score)
 	}
 
-	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
+	entries = append(entries[:pos], entries[pos+1:]...)
+	if len(entries) == 0 {
+		if err = r.redisClient.ZRemMember(ctx, r.getScoreIndexKey(), member); err != nil {
+			return fmt.Errorf("redis ring rem zrem failed, err: %w", err)
+		}
+		if err = r.redisClient.HDel(ctx, r.getVirtualNodeKey(), member); err != nil {
+			return fmt.Errorf("redis ring rem hdel failed, err: %w", err)
+		}
+		return nil
 	}
 
-	return -1, nil
+	newEntries, _ := json.Marshal(entries)
+	if err = r.redisClient.HSet(ctx, r.getVirtualNodeKey(), member, string(newEntries)); err != nil {
+		return fmt.Errorf("redis ring rem hset failed, err: %w", err)
+	}
+	return nil
 }
 
-func (r *RedisHashRing) Rem(ctx context.Context, score int32, nodeID string) error {
-	// rem 操作本质上是要在 score 中删去一个 nodeID
-	scoreEntities, err := r.redisClient.ZRangeByScore(ctx, r.getTableKey(), int64(score), int64(score))
+// 旧版本的哈希环尚未升级，且升级所需的锁被其他进程持有. 此前读取过版本号的实例不会返回该错误，而是继续返回上一次的版本号
+var ErrLegacyLayout = errors.New("redis ring legacy layout not upgraded")
+
+// 读取哈希环之前调用. 旧版本的哈希环以 zset 分值存储虚拟节点，新版本读取不到任何虚拟节点；
+// 早期版本的数据 key 不区分哈希环，新版本同样读取不到. 升级标记存在时直接返回，
+// 只有确实检测到旧版本的存储结构时，才尝试获取哈希环的锁完成升级
+func (r *RedisHashRing) ensureUpgraded(ctx context.Context) error {
+	if atomic.LoadInt32(&r.upgraded) == 1 {
+		return nil
+	}
+
+	upgraded, err := r.redisClient.Exists(ctx, r.getLayoutKey())
 	if err != nil {
-		return fmt.Errorf("redis ring rem zrange by score failed, err: %w", err)
+		return fmt.Errorf("redis ring layout exists failed, err: %w", err)
+	}
+	if upgraded {
+		atomic.StoreInt32(&r.upgraded, 1)
+		return nil
 	}
 
-	if len(scoreEntities) != 1 {
-		return fmt.Errorf("redis ring rem failed, invalid score entity len: %d", len(scoreEntities))
+	legacy, err := r.legacy(ctx)
+	if err != nil {
+		return err
+	}
+	if !legacy {
+		return r.markUpgraded(ctx)
+	}
+
+	// 加锁成功时即完成了升级
+	if err = r.Lock(ctx, 0); err != nil {
+		return fmt.Errorf("%w, err: %v", ErrLegacyLayout, err)
+	}
+	return r.Unlock(ctx)
+}
+
+// 需要持有哈希环的锁
+func (r *RedisHashRing) upgradeLegacy(ctx context.Context) error {
+	if atomic.LoadInt32(&r.upgraded) == 1 {
+		return nil
 	}
 
-	var nodeIDs []string
-	if err = json.Unmarshal([]byte(scoreEntities[0].Val), &nodeIDs); err != nil {
+	upgraded, err := r.redisClient.Exists(ctx, r.getLayoutKey())
+	if err != nil {
+		return fmt.Errorf("redis ring layout exists failed, err: %w", err)
+	}
+	if upgraded {
+		atomic.StoreInt32(&r.upgraded, 1)
+		return nil
+	}
+
+	legacy, err := r.legacy(ctx)
+	if err != nil {
 		return err
 	}
+	if legacy {
+		if err = r.UpgradeLegacyTable(ctx); err != nil {
+			return err
+		}
+	}
+	return r.markUpgraded(ctx)
+}
 
-	index := -1
-	for i := 0; i < len(nodeIDs); i++ {
-		if nodeIDs[i] == nodeID {
-			index = i
-			break
+// 写入升级标记，此后各进程只需要检查标记
+func (r *RedisHashRing) markUpgraded(ctx context.Context) error {
+	if err := r.redisClient.Set(ctx, r.getLayoutKey(), "1"); err != nil {
+		return fmt.Errorf("redis ring layout set failed, err: %w", err)
+	}
+	atomic.StoreInt32(&r.upgraded, 1)
+	return nil
+}
+
+// 是否存在旧版本的虚拟节点表，或者任一节点下存在旧版本的数据 key
+func (r *RedisHashRing) legacy(ctx context.Context) (bool, error) {
+	legacy, err := r.redisClient.Exists(ctx, r.getTableKey())
+	if err != nil {
+		return false, fmt.Errorf("redis ring legacy table exists failed, err: %w", err)
+	}
+	if legacy {
+		return true, nil
+	}
+
+	nodes, err := r.Nodes(ctx)
+	if err != nil {
+		return false, err
+	}
+	for nodeID := range nodes {
+		if legacy, err = r.hasLegacyDataKeys(ctx, nodeID); err != nil || legacy {
+			return legacy, err
 		}
 	}
+	return false, nil
+}
 
-	if index == -1 {
-		return nil
+// 将旧版本的哈希环升级为当前的存储结构：以 zset 分值存储 32 位 score 的虚拟节点表迁移为支持 64 位 score 的存储结构，
+// 各节点旧版本的数据 key 通过 UpgradeLegacyDataKeys 迁移. 旧版本的虚拟节点以 "nodeID_index" 的形式存储，
+// 迁移时按照该格式一次性解析为 (nodeID, index). 需要在持有哈希环锁的前提下调用.
+// 旧的虚拟节点表最后删除，中途失败时可以重新执行. 通常无需手动调用，Lock 与 Version 会在检测到旧版本时自动升级
+func (r *RedisHashRing) UpgradeLegacyTable(ctx context.Context) error {
+	scoreEntities, err := r.redisClient.ZRangeByScore(ctx, r.getTableKey(), math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return fmt.Errorf("redis ring upgrade zrange by score failed, err: %w", err)
 	}
 
-	if err = r.redisClient.ZRem(ctx, r.getTableKey(), scoreEntities[0].Score); err != nil {
-		return fmt.Errorf("redis ring rem zrem failed, err: %w", err)
+	for _, scoreEntity := range scoreEntities {
+		var rawNodeKeys []string
+		if err = json.Unmarshal([]byte(scoreEntity.Val), &rawNodeKeys); err != nil {
+			return err
+		}
+		for _, rawNodeKey := range rawNodeKeys {
+			sep := strings.LastIndex(rawNodeKey, "_")
+			if sep == -1 {
+				return fmt.Errorf("invalid legacy virtual node: %s", rawNodeKey)
+			}
+			index, err := strconv.Atoi(rawNodeKey[sep+1:])
+			if err != nil {
+				return fmt.Errorf("invalid legacy virtual node: %s, err: %w", rawNodeKey, err)
+			}
+			if err = r.Add(ctx, scoreEntity.Score, rawNodeKey[:sep], index); err != nil {
+				return err
+			}
+		}
 	}
 
-	nodeIDs = append(nodeIDs[:index], nodeIDs[index+1:]...)
-	if len(nodeIDs) == 0 {
-		return nil
+	nodes, err := r.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	for nodeID := range nodes {
+		if err = r.UpgradeLegacyDataKeys(ctx, nodeID); err != nil {
+			return err
+		}
 	}
 
-	newNodeIDStr, _ := json.Marshal(nodeIDs)
-	if err = r.redisClient.ZAdd(ctx, r.getTableKey(), scoreEntities[0].Score, string(newNodeIDStr)); err != nil {
-		return fmt.Errorf("redis ring rem zadd failed, err: %w", err)
+	if err = r.redisClient.Del(ctx, r.getTableKey()); err != nil {
+		return fmt.Errorf("redis ring upgrade del failed, err: %w", err)
 	}
 	return nil
 }
@@ -199,86 +420,214 @@ func (r *RedisHashRing) DeleteNodeToReplica(ctx context.Context, nodeID string)
 	return nil
 }
 
-func (r *RedisHashRing) Node(ctx context.Context, score int32) ([]string, error) {
-	scoreEntities, err := r.redisClient.ZRangeByScore(ctx, r.getTableKey(), int64(score), int64(score))
+func (r *RedisHashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	entries, err := r.scoreEntries(ctx, encodeScore(score))
 	if err != nil {
-		return nil, fmt.Errorf("redis ring node zrange by score failed, err: %w", err)
+		return nil, fmt.Errorf("redis ring node hget failed, err: %w", err)
 	}
 
-	if len(scoreEntities) != 1 {
-		return nil, fmt.Errorf("redis ring node failed, invalid len of score entities: %d", len(scoreEntities))
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("redis ring node failed, score not exist: %d", score)
 	}
 
-	var nodeIDs []string
-	if err = json.Unmarshal([]byte(scoreEntities[0].Val), &nodeIDs); err != nil {
-		return nil, err
+	// 同一个节点的多个虚拟节点可能碰撞到同一个 score 上，只返回一次
+	nodeIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !contains(nodeIDs, entry.NodeID) {
+			nodeIDs = append(nodeIDs, entry.NodeID)
+		}
 	}
-
 	return nodeIDs, nil
 }
 
-func (r *RedisHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
-	resStr, err := r.redisClient.Get(ctx, r.getNodeDataKey(nodeID))
-	if err != nil && !errors.Is(err, redis.ErrNil) {
-		return nil, fmt.Errorf("redis ring dataKeys get failed, err: %w", err)
+func contains(nodeIDs []string, nodeID string) bool {
+	for _, _nodeID := range nodeIDs {
+		if _nodeID == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RedisHashRing) NodeMetas(ctx context.Context) (map[string]string, error) {
+	metas, err := r.redisClient.HGetAll(ctx, r.getNodeMetaKey())
+	if err != nil {
+		return nil, fmt.Errorf("redis ring node metas hgetall failed, err: %w", err)
+	}
+	return metas, nil
+}
+
+func (r *RedisHashRing) SetNodeMeta(ctx context.Context, nodeID, meta string) error {
+	if err := r.redisClient.HSet(ctx, r.getNodeMetaKey(), nodeID, meta); err != nil {
+		return fmt.Errorf("redis ring set node meta failed, err: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisHashRing) DeleteNodeMeta(ctx context.Context, nodeID string) error {
+	if err := r.redisClient.HDel(ctx, r.getNodeMetaKey(), nodeID); err != nil {
+		return fmt.Errorf("redis ring delete node meta failed, err: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisHashRing) NodeStates(ctx context.Context) (map[string]string, error) {
+	states, err := r.redisClient.HGetAll(ctx, r.getNodeStateKey())
+	if err != nil {
+		return nil, fmt.Errorf("redis ring node states hgetall failed, err: %w", err)
+	}
+	return states, nil
+}
+
+func (r *RedisHashRing) SetNodeState(ctx context.Context, nodeID, state string) error {
+	if err := r.redisClient.HSet(ctx, r.getNodeStateKey(), nodeID, state); err != nil {
+		return fmt.Errorf("redis ring set node state failed, err: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisHashRing) DeleteNodeState(ctx context.Context, nodeID string) error {
+	if err := r.redisClient.HDel(ctx, r.getNodeStateKey(), nodeID); err != nil {
+		return fmt.Errorf("redis ring delete node state failed, err: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisHashRing) VirtualNodes(ctx context.Context) (map[int64]map[string][]int, error) {
+	rawVirtualNodes, err := r.redisClient.HGetAll(ctx, r.getVirtualNodeKey())
+	if err != nil {
+		return nil, fmt.Errorf("redis ring virtual nodes hgetall failed, err: %w", err)
 	}
 
-	dataKeys := make(map[string]struct{})
-	if len(resStr) > 0 {
-		if err = json.Unmarshal([]byte(resStr), &dataKeys); err != nil {
+	virtualNodes := make(map[int64]map[string][]int, len(rawVirtualNodes))
+	for member, rawEntries := range rawVirtualNodes {
+		score, err := decodeScore(member)
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []virtualNodeEntry
+		if err = json.Unmarshal([]byte(rawEntries), &entries); err != nil {
 			return nil, err
 		}
-	}
 
-	return dataKeys, nil
+		nodes := make(map[string][]int, len(entries))
+		for _, entry := range entries {
+			nodes[entry.NodeID] = append(nodes[entry.NodeID], entry.Index)
+		}
+		virtualNodes[score] = nodes
+	}
+	return virtualNodes, nil
 }
 
-func (r *RedisHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
-	resStr, err := r.redisClient.Get(ctx, r.getNodeDataKey(nodeID))
+// 读取哈希环之前总会先读取版本号，因此在此检测并升级旧版本的存储结构
+func (r *RedisHashRing) Version(ctx context.Context) (int64, error) {
+	if err := r.ensureUpgraded(ctx); err != nil {
+		// 升级所需的锁被其他进程持有时返回上一次读到的版本号，调用方继续使用旧快照
+		if lastVersion := atomic.LoadInt64(&r.lastVersion); errors.Is(err, ErrLegacyLayout) && lastVersion >= 0 {
+			return lastVersion, nil
+		}
+		return 0, err
+	}
+
+	resStr, err := r.redisClient.Get(ctx, r.getVersionKey())
 	if err != nil && !errors.Is(err, redis.ErrNil) {
-		return fmt.Errorf("redis ring addNodeToDataKey get failed, err: %w", err)
+		return 0, fmt.Errorf("redis ring version get failed, err: %w", err)
 	}
+	version := gocast.ToInt64(resStr)
+	atomic.StoreInt64(&r.lastVersion, version)
+	return version, nil
+}
 
-	var oldDataKeys map[string]struct{}
-	if len(resStr) > 0 {
-		if err = json.Unmarshal([]byte(resStr), &oldDataKeys); err != nil {
-			return err
-		}
+func (r *RedisHashRing) IncrVersion(ctx context.Context) (int64, error) {
+	version, err := r.redisClient.Incr(ctx, r.getVersionKey())
+	if err != nil {
+		return 0, fmt.Errorf("redis ring incr version failed, err: %w", err)
 	}
+	return version, nil
+}
 
-	if oldDataKeys == nil {
-		oldDataKeys = make(map[string]struct{})
+func (r *RedisHashRing) RingConfig(ctx context.Context) (string, error) {
+	config, err := r.redisClient.Get(ctx, r.getRingConfigKey())
+	if errors.Is(err, redis.ErrNil) {
+		return "", nil
 	}
-	for dataKey := range dataKeys {
-		oldDataKeys[dataKey] = struct{}{}
+	if err != nil {
+		return "", fmt.Errorf("redis ring config get failed, err: %w", err)
 	}
+	return config, nil
+}
 
-	dataKeysStr, _ := json.Marshal(oldDataKeys)
-	if err = r.redisClient.Set(ctx, r.getNodeDataKey(nodeID), string(dataKeysStr)); err != nil {
-		return fmt.Errorf("redis ring addNodeToDataKey set failed, err: %w", err)
+func (r *RedisHashRing) SetRingConfig(ctx context.Context, config string) error {
+	var err error
+	if config == "" {
+		err = r.redisClient.Del(ctx, r.getRingConfigKey())
+	} else {
+		err = r.redisClient.Set(ctx, r.getRingConfigKey(), config)
+	}
+	if err != nil {
+		return fmt.Errorf("redis ring set config failed, err: %w", err)
 	}
 	return nil
 }
 
-func (r *RedisHashRing) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
-	resStr, err := r.redisClient.Get(ctx, r.getNodeDataKey(nodeID))
-	if err != nil {
-		return fmt.Errorf("redis ring addNodeToDataKey get failed, err: %w", err)
+func (r *RedisHashRing) DataKeyReplicas(ctx context.Context) (int, error) {
+	resStr, err := r.redisClient.Get(ctx, r.getDataKeyReplicasKey())
+	if err != nil && !errors.Is(err, redis.ErrNil) {
+		return 0, fmt.Errorf("redis ring data key replicas get failed, err: %w", err)
 	}
+	return gocast.ToInt(resStr), nil
+}
 
-	var oldDataKeys map[string]struct{}
-	if err = json.Unmarshal([]byte(resStr), &oldDataKeys); err != nil {
-		return err
+// 仅当新的上限更大时写入，读取与写入在同一个 lua 脚本中完成
+const luaRaiseDataKeyReplicas = `
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if current < tonumber(ARGV[1]) then
+  redis.call('SET', KEYS[1], ARGV[1])
+end
+return 0
+`
+
+func (r *RedisHashRing) RaiseDataKeyReplicas(ctx context.Context, replicas int) error {
+	if _, err := r.redisClient.Eval(ctx, luaRaiseDataKeyReplicas, 1, []interface{}{r.getDataKeyReplicasKey(), replicas}); err != nil {
+		return fmt.Errorf("redis ring raise data key replicas failed, err: %w", err)
 	}
+	return nil
+}
 
-	for dataKey := range dataKeys {
-		delete(oldDataKeys, dataKey)
+func (r *RedisHashRing) SetMigrationJob(ctx context.Context, jobID string, fields map[string]string) error {
+	if err := r.redisClient.HMSet(ctx, r.getMigrationJobKey(jobID), fields); err != nil {
+		return fmt.Errorf("redis ring set migration job failed, err: %w", err)
 	}
+	return nil
+}
 
-	if len(oldDataKeys) == 0 {
-		return r.redisClient.Del(ctx, r.getNodeDataKey(nodeID))
+func (r *RedisHashRing) MigrationJob(ctx context.Context, jobID string) (map[string]string, error) {
+	fields, err := r.redisClient.HGetAll(ctx, r.getMigrationJobKey(jobID))
+	if err != nil {
+		return nil, fmt.Errorf("redis ring migration job hgetall failed, err: %w", err)
 	}
+	return fields, nil
+}
+
+func (r *RedisHashRing) ExpireMigrationJob(ctx context.Context, jobID string, expireAt int64) error {
+	if err := r.redisClient.PExpireAt(ctx, r.getMigrationJobKey(jobID), expireAt); err != nil {
+		return fmt.Errorf("redis ring expire migration job failed, err: %w", err)
+	}
+	return nil
+}
 
-	newDataKeyStr, _ := json.Marshal(oldDataKeys)
-	return r.redisClient.Set(ctx, r.getNodeDataKey(nodeID), string(newDataKeyStr))
+func (r *RedisHashRing) Publish(ctx context.Context, event string) error {
+	if err := r.redisClient.Publish(ctx, r.getEventChannel(), event); err != nil {
+		return fmt.Errorf("redis ring publish event failed, err: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisHashRing) Subscribe(ctx context.Context) (<-chan string, error) {
+	events, err := r.redisClient.Subscribe(ctx, r.getEventChannel())
+	if err != nil {
+		return nil, fmt.Errorf("redis ring subscribe events failed, err: %w", err)
+	}
+	return events, nil
 }
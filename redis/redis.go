@@ -126,6 +126,17 @@ func (c *Client) ZRangeByScore(ctx context.Context, table string, score1, score2
 	return scoreEntities, nil
 }
 
+// ZRangeByScoreMembers 执行 redis zrangebyscore 命令，只返回成员. min 与 max 遵循 redis 的区间语法.
+// zrange 的 byscore 参数需要 redis 6.2 及以上版本，因此使用兼容更早版本的 zrangebyscore
+func (c *Client) ZRangeByScoreMembers(ctx context.Context, table, min, max string) ([]string, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return redis.Strings(conn.Do("ZRANGEBYSCORE", table, min, max))
+}
+
 // 返回大于等于 score 的第一个目标
 func (c *Client) Ceiling(ctx context.Context, table string, score int64) (*ScoreEntity, error) {
 	conn, err := c.pool.GetContext(ctx)
@@ -210,6 +221,46 @@ func (c *Client) ZRem(ctx context.Context, table string, score int64) error {
 	return err
 }
 
+// ZRangeByLex 按照字典序返回 [min, max] 之间的至多 limit 个成员
+func (c *Client) ZRangeByLex(ctx context.Context, table, min, max string, limit int) ([]string, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return redis.Strings(conn.Do("ZRANGEBYLEX", table, min, max, "LIMIT", 0, limit))
+}
+
+// ZRevRangeByLex 按照字典序逆序返回 [max, min] 之间的至多 limit 个成员
+func (c *Client) ZRevRangeByLex(ctx context.Context, table, max, min string, limit int) ([]string, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return redis.Strings(conn.Do("ZREVRANGEBYLEX", table, max, min, "LIMIT", 0, limit))
+}
+
+func (c *Client) ZRemMember(ctx context.Context, table, member string) error {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("ZREM", table, member)
+	return err
+}
+
+// HGet 执行 redis hget 命令，field 不存在时返回 redis.ErrNil
+func (c *Client) HGet(ctx context.Context, table, key string) (string, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return redis.String(conn.Do("HGET", table, key))
+}
+
 func (c *Client) HSet(ctx context.Context, table, key, val string) error {
 	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
@@ -220,6 +271,26 @@ func (c *Client) HSet(ctx context.Context, table, key, val string) error {
 	return err
 }
 
+func (c *Client) HMSet(ctx context.Context, table string, fields map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	args := make([]interface{}, 0, 1+len(fields)<<1)
+	args = append(args, table)
+	for key, val := range fields {
+		args = append(args, key, val)
+	}
+	_, err = conn.Do("HSET", args...)
+	return err
+}
+
 func (c *Client) HGetAll(ctx context.Context, table string) (map[string]string, error) {
 	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
@@ -229,16 +300,73 @@ func (c *Client) HGetAll(ctx context.Context, table string) (map[string]string,
 	return redis.StringMap(conn.Do("HGETALL", table))
 }
 
-func (c *Client) HDel(ctx context.Context, table, key string) error {
+func (c *Client) HDel(ctx context.Context, table string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
 	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	_, err = conn.Do("HDEL", table, key)
+
+	args := make([]interface{}, 0, 1+len(keys))
+	args = append(args, table)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	_, err = conn.Do("HDEL", args...)
 	return err
 }
 
+// HMGet 执行 redis hmget 命令，不存在的 field 对应空字符串
+func (c *Client) HMGet(ctx context.Context, table string, keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	args := make([]interface{}, 0, 1+len(keys))
+	args = append(args, table)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return redis.Strings(conn.Do("HMGET", args...))
+}
+
+// HScan 执行 redis hscan 命令，返回本轮遍历到的 field/value 以及下一轮的游标，游标为 "0" 时代表遍历结束
+func (c *Client) HScan(ctx context.Context, table, cursor string, count int) (map[string]string, string, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+
+	reply, err := redis.Values(conn.Do("HSCAN", table, cursor, "COUNT", count))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(reply) != 2 {
+		return nil, "", fmt.Errorf("invalid hscan reply length: %d", len(reply))
+	}
+
+	next, err := redis.String(reply[0], nil)
+	if err != nil {
+		return nil, "", err
+	}
+	fields, err := redis.StringMap(reply[1], nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return fields, next, nil
+}
+
 func (c *Client) Set(ctx context.Context, key, val string) error {
 	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
@@ -258,6 +386,15 @@ func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	return redis.String(conn.Do("GET", key))
 }
 
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return redis.Int64(conn.Do("INCR", key))
+}
+
 func (c *Client) Del(ctx context.Context, key string) error {
 	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
@@ -268,6 +405,81 @@ func (c *Client) Del(ctx context.Context, key string) error {
 	return err
 }
 
+// PExpireAt 设置 key 的过期时间（unix 毫秒）
+func (c *Client) PExpireAt(ctx context.Context, key string, expireAt int64) error {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PEXPIREAT", key, expireAt)
+	return err
+}
+
+// Exists 任一 key 存在时返回 true
+func (c *Client) Exists(ctx context.Context, keys ...string) (bool, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	count, err := redis.Int64(conn.Do("EXISTS", args...))
+	return count > 0, err
+}
+
+// Publish 执行 redis publish 命令
+func (c *Client) Publish(ctx context.Context, channel, message string) error {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PUBLISH", channel, message)
+	return err
+}
+
+// Subscribe 订阅 channel，独占一个连接. ctx 结束或者连接异常时取消订阅，并关闭返回的 channel
+func (c *Client) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	psc := redis.PubSubConn{Conn: conn}
+	if err = psc.Subscribe(channel); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer psc.Close()
+		for {
+			switch reply := psc.ReceiveContext(ctx).(type) {
+			case redis.Message:
+				select {
+				case messages <- string(reply.Data):
+				case <-ctx.Done():
+					return
+				}
+			case redis.Subscription:
+				if reply.Count == 0 {
+					return
+				}
+			case error:
+				return
+			}
+		}
+	}()
+	return messages, nil
+}
+
 // Eval 支持使用 lua 脚本.
 func (c *Client) Eval(ctx context.Context, src string, keyCount int, keysAndArgs []interface{}) (interface{}, error) {
 	args := make([]interface{}, 2+len(keysAndArgs))
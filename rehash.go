@@ -0,0 +1,154 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+)
+
+// 将 source 哈希环中的节点迁移到当前实例的哈希环中，当前实例的哈希环必须为空.
+// 通常用于将 32 位哈希空间的哈希环迁移到 64 位哈希空间：节点的虚拟节点个数、元数据与状态保持不变，
+// 虚拟节点按照当前实例的 Encryptor 与放置策略重新计算. 数据 key 先记录在原来的节点下，
+// 再对比迁移前后的归属调用 Migrator. 迁移期间会同时锁住两个哈希环
+func (c *ConsistentHash) MigrateFrom(ctx context.Context, source *ConsistentHash) (*MigrationReport, error) {
+	if source == c || source.hashRing == c.hashRing {
+		return nil, errors.New("migrate from the same ring")
+	}
+
+	if err := source.hashRing.Lock(ctx, source.opts.lockExpireSeconds); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = source.hashRing.Unlock(ctx)
+	}()
+
+	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = c.hashRing.Unlock(ctx)
+	}()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) > 0 {
+		return nil, errors.New("migrate into a non-empty ring")
+	}
+
+	sourceView, err := source.readRingView(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 任意一方不记录数据 key 时，只迁移哈希环的拓扑
+	dataKeys := make(map[string]map[string]struct{}, len(sourceView.nodes))
+	expireAts := make(map[string]map[string]int64, len(sourceView.nodes))
+	if source.dataKeyIndex != nil && c.dataKeyIndex != nil {
+		for nodeID := range sourceView.nodes {
+			if dataKeys[nodeID], err = source.dataKeyIndex.DataKeys(ctx, nodeID); err != nil {
+				return nil, err
+			}
+			if expireAts[nodeID], err = source.dataKeyIndex.DataKeysExpireAt(ctx, nodeID, dataKeys[nodeID]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	version, migrateTasks, err := c.commit(ctx, func(tx *ringTx, before *ringSnapshot) error {
+		view := &ringView{
+			virtualNodes: make(map[int64]map[string][]int),
+			nodes:        make(map[string]int, len(sourceView.nodes)),
+			metas:        sourceView.metas,
+			states:       sourceView.states,
+		}
+
+		for _, nodeID := range source.newRingSnapshot(0, sourceView).joinOrder() {
+			replicas, ok := sourceView.nodes[nodeID]
+			if !ok {
+				continue
+			}
+			if err := tx.AddNodeToReplica(ctx, nodeID, replicas); err != nil {
+				return err
+			}
+			if meta, ok := sourceView.metas[nodeID]; ok {
+				if err := c.setNodeMeta(ctx, tx, nodeID, meta); err != nil {
+					return err
+				}
+			}
+			if state, ok := sourceView.states[nodeID]; ok {
+				if err := tx.SetNodeState(ctx, nodeID, string(state)); err != nil {
+					return err
+				}
+			}
+
+			// 部分放置策略依赖已加入的节点推算虚拟节点，因此逐个节点推演
+			virtualNodes, err := c.placement.join(c.newRingSnapshot(before.version, view), nodeID, replicas)
+			if err != nil {
+				return err
+			}
+			for _, virtualNode := range virtualNodes {
+				if err := tx.Add(ctx, virtualNode); err != nil {
+					return err
+				}
+				view.add(virtualNode)
+			}
+			view.nodes[nodeID] = replicas
+		}
+
+		// 一个数据 key 被记录在几个节点下，就拥有几个副本
+		holders := make(map[string]int)
+		var maxReplicas int
+		for _, _dataKeys := range dataKeys {
+			for dataKey := range _dataKeys {
+				if holders[dataKey]++; holders[dataKey] > maxReplicas {
+					maxReplicas = holders[dataKey]
+				}
+			}
+		}
+		// 与 Import 相同，副本数上限不随回滚恢复
+		if err := c.hashRing.RaiseDataKeyReplicas(ctx, maxReplicas); err != nil {
+			return err
+		}
+
+		for nodeID, _dataKeys := range dataKeys {
+			if err := tx.AddNodeToDataKeys(ctx, nodeID, _dataKeys); err != nil {
+				return err
+			}
+			if err := expireDataKeys(ctx, c.dataKeyIndex, nodeID, expireAts[nodeID]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.executeMigration(ctx, version, migrateTasks)
+}
+
+// 节点加入哈希环的顺序. 按照虚拟节点 score 的顺序排列，使得 jump hash 等依赖节点顺序的放置策略保持原有的分桶；
+// 没有虚拟节点的节点按照 id 排在最后
+func (r *ringSnapshot) joinOrder() []string {
+	nodeIDs := make([]string, 0, len(r.members))
+	ranged := make(map[string]struct{}, len(r.members))
+	for _, scoreNodeIDs := range r.nodes {
+		for _, nodeID := range scoreNodeIDs {
+			if _, ok := ranged[nodeID]; ok {
+				continue
+			}
+			ranged[nodeID] = struct{}{}
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+
+	for _, nodeID := range r.members {
+		if _, ok := ranged[nodeID]; !ok {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+	return nodeIDs
+}
@@ -0,0 +1,116 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_migrate_from_32bit_ring(t *testing.T) {
+	ctx := context.Background()
+	source := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	})
+
+	if _, err := source.AddNode(ctx, "node_a", 2, WithNodeMeta(NodeMeta{Address: "10.0.0.1:6379"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.AddNode(ctx, "node_c", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := source.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mutex sync.Mutex
+	moved := make(map[string]string)
+	target := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher64(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for dataKey := range dataKeys {
+			moved[dataKey] = to
+		}
+		return nil
+	})
+
+	report, err := target.MigrateFrom(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.KeyCount() != len(moved) || len(moved) == 0 {
+		t.Fatalf("unexpected migrations: %d, moved: %d", report.KeyCount(), len(moved))
+	}
+
+	snapshot, err := target.loadSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.scores[len(snapshot.scores)-1] <= math.MaxInt32 {
+		t.Fatal("expect virtual nodes in 64 bit hash space")
+	}
+	if snapshot.weights["node_a"] != 2*snapshot.weights["node_b"] {
+		t.Fatalf("unexpected weights: %v", snapshot.weights)
+	}
+	if snapshot.metas["node_a"].Address != "10.0.0.1:6379" {
+		t.Fatalf("unexpected meta: %+v", snapshot.metas["node_a"])
+	}
+
+	// 全部数据 key 都记录在 64 位哈希环的归属节点下
+	for nodeID := range snapshot.weights {
+		dataKeys, err := target.dataKeyIndex.DataKeys(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for dataKey := range dataKeys {
+			if expect := snapshot.walk(target.hash(dataKey), 1)[0]; expect != nodeID {
+				t.Fatalf("data key: %s, expect node: %s, got: %s", dataKey, expect, nodeID)
+			}
+			if to, ok := moved[dataKey]; ok && to != nodeID {
+				t.Fatalf("data key: %s, migrated to: %s, recorded in: %s", dataKey, to, nodeID)
+			}
+		}
+	}
+
+	if _, err = target.MigrateFrom(ctx, source); err == nil {
+		t.Fatal("expect error when migrating into a non-empty ring")
+	}
+}
+
+// 所有虚拟节点都碰撞到同一个 score 上
+type collidingEncryptor struct{}
+
+func (c collidingEncryptor) Encrypt(origin string) int32 {
+	return 1
+}
+
+func Test_score_collision(t *testing.T) {
+	ctx := context.Background()
+	var routed []string
+	for _, nodeIDs := range [][]string{{"node_a", "node_b"}, {"node_b", "node_a"}} {
+		consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), collidingEncryptor{}, nil)
+		for _, nodeID := range nodeIDs {
+			if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		node, err := consistentHash.GetNode(ctx, "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		routed = append(routed, node)
+	}
+
+	if routed[0] != "node_a" || routed[1] != "node_a" {
+		t.Fatalf("collision should be resolved by node id, got: %v", routed)
+	}
+}
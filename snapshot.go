@@ -0,0 +1,378 @@
+package consistent_hash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// 哈希环的只读快照. 快照一经发布就不再修改，因此 GetNode 可以在不加锁的情况下读取
+type ringSnapshot struct {
+	// 构造快照时哈希环的版本号
+	version int64
+	// 升序排列的虚拟节点 score
+	scores []int64
+	// 与 scores 一一对应，每个 score 下的物理节点 id 列表
+	nodes [][]string
+	// 升序排列的物理节点 id，以及每个物理节点对应的虚拟节点个数
+	members []string
+	weights map[string]int
+	// 每个物理节点的元数据
+	metas map[string]NodeMeta
+	// 处于非 active 状态的物理节点
+	states map[string]NodeState
+	// 哈希环上不同物理节点的个数
+	nodeCount int
+	// 基于快照定位数据 key 所使用的放置策略
+	placement placement
+	// maglev 查找表，每个槽位记录物理节点在 members 中的下标
+	lookup []int
+	// ketama 环上升序排列的点
+	continuum []ketamaPoint
+}
+
+func (c *ConsistentHash) newRingSnapshot(version int64, view *ringView) *ringSnapshot {
+	virtualNodes, nodes := view.virtualNodes, view.nodes
+	snapshot := ringSnapshot{
+		version:   version,
+		scores:    make([]int64, 0, len(virtualNodes)),
+		nodes:     make([][]string, 0, len(virtualNodes)),
+		members:   make([]string, 0, len(nodes)),
+		weights:   make(map[string]int, len(nodes)),
+		metas:     view.metas,
+		states:    view.states,
+		placement: c.placement,
+	}
+
+	for score := range virtualNodes {
+		snapshot.scores = append(snapshot.scores, score)
+	}
+	sort.Slice(snapshot.scores, func(i, j int) bool {
+		return snapshot.scores[i] < snapshot.scores[j]
+	})
+
+	for _, score := range snapshot.scores {
+		nodeIDs := make([]string, 0, len(virtualNodes[score]))
+		for nodeID := range virtualNodes[score] {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+		// 多个虚拟节点的 score 发生碰撞时，按照节点 id 排序，保证与写入顺序无关，各进程的路由结果一致
+		sort.Strings(nodeIDs)
+		snapshot.nodes = append(snapshot.nodes, nodeIDs)
+	}
+
+	for nodeID, replicas := range nodes {
+		snapshot.members = append(snapshot.members, nodeID)
+		snapshot.weights[nodeID] = replicas
+	}
+	sort.Strings(snapshot.members)
+	snapshot.nodeCount = len(snapshot.members)
+	c.placement.build(&snapshot)
+	return &snapshot
+}
+
+// 获得 >= score 且最接近 score 的虚拟节点下标，越过环尾时回到首个虚拟节点. 快照为空时返回 -1
+func (r *ringSnapshot) ceiling(score int64) int {
+	if len(r.scores) == 0 {
+		return -1
+	}
+
+	index := sort.Search(len(r.scores), func(i int) bool {
+		return r.scores[i] >= score
+	})
+	if index == len(r.scores) {
+		return 0
+	}
+	return index
+}
+
+// 从 dataScore 开始沿顺时针方向行走，返回前 n 个不同的物理节点. 同一个物理节点的其他虚拟节点会被跳过，
+// 哈希环上的物理节点不足 n 个时，返回全部物理节点
+func (r *ringSnapshot) walk(dataScore int64, n int) []string {
+	start := r.ceiling(dataScore)
+	if start == -1 {
+		return nil
+	}
+	return r.walkFrom(start, n)
+}
+
+// 从下标为 start 的虚拟节点开始行走，返回前 n 个不同的物理节点
+func (r *ringSnapshot) walkFrom(start, n int) []string {
+	if n > r.nodeCount {
+		n = r.nodeCount
+	}
+
+	nodeIDs := make([]string, 0, n)
+	ranged := make(map[string]struct{}, n)
+	for i := 0; i < len(r.scores) && len(nodeIDs) < n; i++ {
+		for _, nodeID := range r.nodes[(start+i)%len(r.scores)] {
+			if _, ok := ranged[nodeID]; ok {
+				continue
+			}
+			ranged[nodeID] = struct{}{}
+			nodeIDs = append(nodeIDs, nodeID)
+			if len(nodeIDs) == n {
+				break
+			}
+		}
+	}
+	return nodeIDs
+}
+
+// 按照放置策略，返回数据 key 的前 n 个不同的物理节点
+func (r *ringSnapshot) locate(dataKey string, dataScore int64, n int) []string {
+	return r.placement.locate(r, dataKey, dataScore, n)
+}
+
+// 获取当前可用的快照. 倘若哈希环的版本号已经前进，则需要重新构造快照
+func (c *ConsistentHash) loadSnapshot(ctx context.Context) (*ringSnapshot, error) {
+	version, err := c.hashRing.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, _ := c.snapshot.Load().(*ringSnapshot)
+	if snapshot != nil && snapshot.version >= version {
+		return snapshot, nil
+	}
+
+	// 其他进程正在修改哈希环，此时读到的虚拟节点表可能不完整，继续使用旧快照
+	if snapshot != nil && isWriting(version) {
+		return snapshot, nil
+	}
+
+	// 同一时刻只由一个 goroutine 负责重建快照，其他 goroutine 继续使用旧快照
+	if snapshot != nil && !c.refreshMutex.TryLock() {
+		return snapshot, nil
+	}
+	if snapshot == nil {
+		c.refreshMutex.Lock()
+	}
+	defer c.refreshMutex.Unlock()
+
+	// double check，可能其他 goroutine 已经完成了重建
+	if latest, _ := c.snapshot.Load().(*ringSnapshot); latest != nil && latest.version >= version {
+		return latest, nil
+	}
+
+	latest, err := c.refreshSnapshot(ctx)
+	if err != nil {
+		// 重建失败时，倘若存在旧快照则降级使用
+		if snapshot != nil {
+			return snapshot, nil
+		}
+		return nil, err
+	}
+	return latest, nil
+}
+
+const (
+	// 重建快照时，读取期间哈希环发生变更的最大重试次数
+	refreshSnapshotRetries = 50
+	// 两次重试之间的等待时长
+	refreshSnapshotInterval = 10 * time.Millisecond
+)
+
+// 哈希环正在被修改而未能读到稳定的状态
+var ErrRingChanging = errors.New("hash ring is changing")
+
+// 版本号为奇数时，哈希环正在被修改，见 markWriting
+func isWriting(version int64) bool {
+	return version%2 != 0
+}
+
+// 其他进程修改了哈希环，需要重新读取完整的虚拟节点表. 读取前后对比版本号，
+// 倘若哈希环正在被修改或者读取期间发生了变更，则稍后重试，不获取哈希环的锁
+func (c *ConsistentHash) refreshSnapshot(ctx context.Context) (*ringSnapshot, error) {
+	for i := 0; i < refreshSnapshotRetries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(refreshSnapshotInterval):
+			}
+		}
+
+		version, err := c.hashRing.Version(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if isWriting(version) {
+			continue
+		}
+
+		snapshot, err := c.buildSnapshot(ctx, version)
+		if err != nil {
+			return nil, err
+		}
+
+		latest, err := c.hashRing.Version(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if latest != version {
+			continue
+		}
+
+		c.snapshot.Store(snapshot)
+		return snapshot, nil
+	}
+	return nil, ErrRingChanging
+}
+
+// 在持有哈希环锁的前提下，读取哈希环当前的状态，仅用于对比，不对外发布
+func (c *ConsistentHash) currentSnapshot(ctx context.Context) (*ringSnapshot, error) {
+	version, err := c.hashRing.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.buildSnapshot(ctx, version)
+}
+
+// 在持有哈希环锁的前提下，读取完整的虚拟节点表构造快照
+func (c *ConsistentHash) buildSnapshot(ctx context.Context, version int64) (*ringSnapshot, error) {
+	view, err := c.readRingView(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.newRingSnapshot(version, view), nil
+}
+
+// 构造快照所需的哈希环原始数据. 可以在副本上推演节点变更，而不修改 HashRing
+type ringView struct {
+	virtualNodes map[int64]map[string][]int
+	nodes        map[string]int
+	metas        map[string]NodeMeta
+	states       map[string]NodeState
+}
+
+func (c *ConsistentHash) readRingView(ctx context.Context) (*ringView, error) {
+	// 拒绝以不同的 Encryptor 或放置策略读取哈希环，否则会得到错误的数据归属
+	if err := c.checkRingConfig(ctx); err != nil {
+		return nil, err
+	}
+
+	virtualNodes, err := c.hashRing.VirtualNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rawMetas, err := c.hashRing.NodeMetas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make(map[string]NodeMeta, len(rawMetas))
+	for nodeID, rawMeta := range rawMetas {
+		var meta NodeMeta
+		if err = json.Unmarshal([]byte(rawMeta), &meta); err != nil {
+			return nil, fmt.Errorf("invalid meta of node: %s, err: %w", nodeID, err)
+		}
+		metas[nodeID] = meta
+	}
+
+	rawStates, err := c.hashRing.NodeStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]NodeState, len(rawStates))
+	for nodeID, rawState := range rawStates {
+		states[nodeID] = NodeState(rawState)
+	}
+
+	return &ringView{
+		virtualNodes: virtualNodes,
+		nodes:        nodes,
+		metas:        metas,
+		states:       states,
+	}, nil
+}
+
+// 复制虚拟节点表与节点列表，元数据与状态不会在推演中修改，直接共享
+func (v *ringView) clone() *ringView {
+	virtualNodes := make(map[int64]map[string][]int, len(v.virtualNodes))
+	for score, nodes := range v.virtualNodes {
+		virtualNodes[score] = make(map[string][]int, len(nodes))
+		for nodeID, indexes := range nodes {
+			virtualNodes[score][nodeID] = append([]int(nil), indexes...)
+		}
+	}
+
+	nodes := make(map[string]int, len(v.nodes))
+	for nodeID, replicas := range v.nodes {
+		nodes[nodeID] = replicas
+	}
+
+	return &ringView{
+		virtualNodes: virtualNodes,
+		nodes:        nodes,
+		metas:        v.metas,
+		states:       v.states,
+	}
+}
+
+func (v *ringView) add(virtualNode ringVirtualNode) {
+	nodes := v.virtualNodes[virtualNode.score]
+	if nodes == nil {
+		nodes = make(map[string][]int)
+		v.virtualNodes[virtualNode.score] = nodes
+	}
+	nodes[virtualNode.nodeID] = append(nodes[virtualNode.nodeID], virtualNode.index)
+}
+
+func (v *ringView) rem(virtualNode ringVirtualNode) {
+	nodes := v.virtualNodes[virtualNode.score]
+	indexes := nodes[virtualNode.nodeID]
+	for i, index := range indexes {
+		if index != virtualNode.index {
+			continue
+		}
+		indexes = append(indexes[:i:i], indexes[i+1:]...)
+		break
+	}
+
+	if len(indexes) > 0 {
+		nodes[virtualNode.nodeID] = indexes
+		return
+	}
+	delete(nodes, virtualNode.nodeID)
+	if len(nodes) == 0 {
+		delete(v.virtualNodes, virtualNode.score)
+	}
+}
+
+// 节点变更完成后调用，需要持有哈希环的锁并已通过 markWriting 标记写入中.
+// 递增版本号后回到偶数，表示变更完成. 先发布本地快照再递增版本号，
+// 保证本进程内的读请求不会因为看到新版本号而重建快照
+func (c *ConsistentHash) publishSnapshot(ctx context.Context) (*ringSnapshot, error) {
+	version, err := c.hashRing.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 标记写入中失败时版本号仍为偶数，需要先补齐，保证发布后的版本号为偶数
+	if !isWriting(version) {
+		if version, err = c.hashRing.IncrVersion(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshot, err := c.buildSnapshot(ctx, version+1)
+	if err != nil {
+		return nil, err
+	}
+	c.snapshot.Store(snapshot)
+
+	if _, err = c.hashRing.IncrVersion(ctx); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,165 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_snapshot_get_node(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	})
+
+	if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d_%d", i, j)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	if _, err := consistentHash.AddNode(ctx, "node_c", 2); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// 每个数据 key 都只能记录在其当前所属的节点下
+	owners := make(map[string]string)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		dataKeys, _ := hashRing.DataKeys(ctx, nodeID)
+		for dataKey := range dataKeys {
+			if owner, ok := owners[dataKey]; ok {
+				t.Fatalf("data: %s belongs to both %s and %s", dataKey, owner, nodeID)
+			}
+			owners[dataKey] = nodeID
+		}
+	}
+
+	if len(owners) != 800 {
+		t.Fatalf("expect 800 data keys, got %d", len(owners))
+	}
+
+	for dataKey, owner := range owners {
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node != owner {
+			t.Fatalf("data: %s expect node: %s, got: %s", dataKey, owner, node)
+		}
+	}
+}
+
+func Test_snapshot_refresh_without_lock(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+	writer := NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	if _, err := writer.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	getNode := func(consistentHash *ConsistentHash) error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := consistentHash.GetNode(ctx, "data_a")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(2 * time.Second):
+			return fmt.Errorf("get node blocked")
+		}
+	}
+
+	// 其他进程持有哈希环的锁，例如正在执行耗时的数据迁移，不影响读请求重建快照
+	if err := hashRing.Lock(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := getNode(NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithDataKeyTracking(DataKeyTrackingNone))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 哈希环正在被修改时，读请求等待写入完成后再构造快照
+	if err := writer.markWriting(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() {
+		_, _ = hashRing.IncrVersion(ctx)
+	})
+	reader := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithDataKeyTracking(DataKeyTrackingNone))
+	if err := getNode(reader); err != nil {
+		t.Fatal(err)
+	}
+	version, _ := hashRing.Version(ctx)
+	if snapshot, _ := reader.snapshot.Load().(*ringSnapshot); snapshot == nil || snapshot.version != version || isWriting(snapshot.version) {
+		t.Fatalf("expect snapshot of version: %d", version)
+	}
+	_ = hashRing.Unlock(ctx)
+}
+
+func Test_snapshot_shared_ring(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+	writer := NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	if _, err := writer.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 另一个实例共享同一个哈希环，在不加锁的情况下重建快照
+	reader := NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := reader.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		if _, err := writer.AddNode(ctx, fmt.Sprintf("node_%d", i), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
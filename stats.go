@@ -0,0 +1,156 @@
+package consistent_hash
+
+import (
+	"context"
+	"math"
+)
+
+// 哈希环的分布统计，用于评估负载是否均衡
+type RingStats struct {
+	// 统计所基于的哈希环版本号
+	Version int64
+	// 每个物理节点的统计，按照节点 id 升序排列
+	Nodes []*NodeStats
+	// 各节点所拥有的哈希空间比例的分布
+	Ownership DistributionStats
+	// 各节点记录的数据 key 个数的分布，不记录数据 key 时为 nil
+	Keys *DistributionStats
+	// 样本数据 key 在各节点上的分布，未指定样本时为 nil
+	Samples *DistributionStats
+}
+
+// 单个物理节点的统计
+type NodeStats struct {
+	NodeID string
+	// 配置的虚拟节点个数，以及哈希环中实际存在的虚拟节点个数
+	Replicas     int
+	VirtualNodes int
+	// 作为首个副本所拥有的哈希空间比例
+	Ownership float64
+	// 记录在该节点下的数据 key 个数
+	KeyCount int
+	// 首个副本落在该节点上的样本数据 key 个数
+	SampleCount int
+}
+
+// 一组节点负载的分布情况
+type DistributionStats struct {
+	Mean   float64
+	StdDev float64
+	Min    float64
+	Max    float64
+	// 最大值与最小值之比，最小值为 0 而最大值不为 0 时为 +Inf
+	MaxMinRatio float64
+	// 最大值与平均值之比，越接近 1 代表越均衡
+	PeakToAverage float64
+}
+
+type StatsOptions struct {
+	sample []string
+}
+
+type StatsOption func(opts *StatsOptions)
+
+// 统计一组样本数据 key 在各节点上的分布. 只定位样本，不会记录数据 key
+func WithStatsSample(dataKeys []string) StatsOption {
+	return func(opts *StatsOptions) {
+		opts.sample = dataKeys
+	}
+}
+
+// 基于当前的快照统计各节点的虚拟节点个数、哈希空间比例与数据 key 个数. 统计只反映哈希环的拓扑，不考虑节点的状态
+func (c *ConsistentHash) Stats(ctx context.Context, opts ...StatsOption) (*RingStats, error) {
+	var statsOpts StatsOptions
+	for _, opt := range opts {
+		opt(&statsOpts)
+	}
+
+	snapshot, err := c.loadSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualNodes := make(map[string]int, len(snapshot.members))
+	for _, nodeIDs := range snapshot.nodes {
+		for _, nodeID := range nodeIDs {
+			virtualNodes[nodeID]++
+		}
+	}
+
+	ownership := snapshot.placement.ownership(snapshot)
+	stats := RingStats{
+		Version: snapshot.version,
+		Nodes:   make([]*NodeStats, 0, len(snapshot.members)),
+	}
+	indexes := make(map[string]int, len(snapshot.members))
+	for i, nodeID := range snapshot.members {
+		indexes[nodeID] = i
+		stats.Nodes = append(stats.Nodes, &NodeStats{
+			NodeID:       nodeID,
+			Replicas:     snapshot.weights[nodeID],
+			VirtualNodes: virtualNodes[nodeID],
+			Ownership:    ownership[nodeID],
+		})
+	}
+	stats.Ownership = newDistributionStats(stats.Nodes, func(node *NodeStats) float64 {
+		return node.Ownership
+	})
+
+	if c.dataKeyIndex != nil {
+		for _, node := range stats.Nodes {
+			if node.KeyCount, err = c.dataKeyIndex.DataKeysCount(ctx, node.NodeID); err != nil {
+				return nil, err
+			}
+		}
+		keys := newDistributionStats(stats.Nodes, func(node *NodeStats) float64 {
+			return float64(node.KeyCount)
+		})
+		stats.Keys = &keys
+	}
+
+	if len(statsOpts.sample) > 0 && snapshot.nodeCount > 0 {
+		for _, dataKey := range statsOpts.sample {
+			nodeIDs := snapshot.locate(dataKey, c.hash(dataKey), 1)
+			stats.Nodes[indexes[nodeIDs[0]]].SampleCount++
+		}
+		samples := newDistributionStats(stats.Nodes, func(node *NodeStats) float64 {
+			return float64(node.SampleCount)
+		})
+		stats.Samples = &samples
+	}
+	return &stats, nil
+}
+
+func newDistributionStats(nodes []*NodeStats, value func(node *NodeStats) float64) DistributionStats {
+	var stats DistributionStats
+	if len(nodes) == 0 {
+		return stats
+	}
+
+	stats.Min, stats.Max = math.Inf(1), math.Inf(-1)
+	var sum float64
+	for _, node := range nodes {
+		v := value(node)
+		sum += v
+		stats.Min = math.Min(stats.Min, v)
+		stats.Max = math.Max(stats.Max, v)
+	}
+	stats.Mean = sum / float64(len(nodes))
+
+	var variance float64
+	for _, node := range nodes {
+		variance += math.Pow(value(node)-stats.Mean, 2)
+	}
+	stats.StdDev = math.Sqrt(variance / float64(len(nodes)))
+
+	switch {
+	case stats.Min > 0:
+		stats.MaxMinRatio = stats.Max / stats.Min
+	case stats.Max > 0:
+		stats.MaxMinRatio = math.Inf(1)
+	}
+	if stats.Mean > 0 {
+		stats.PeakToAverage = stats.Max / stats.Mean
+	}
+	return stats
+}
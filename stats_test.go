@@ -0,0 +1,88 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_stats(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), nil, WithReplicas(50))
+	for nodeID, weight := range map[string]float64{"node_a": 1, "node_b": 1, "node_c": 2} {
+		if _, err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sample := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		sample = append(sample, fmt.Sprintf("data_%d", i))
+	}
+	for _, dataKey := range sample[:100] {
+		if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := consistentHash.Stats(ctx, WithStatsSample(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Nodes) != 3 || stats.Nodes[0].NodeID != "node_a" || stats.Nodes[2].NodeID != "node_c" {
+		t.Fatalf("unexpected nodes: %+v", stats.Nodes)
+	}
+
+	var ownership float64
+	var keyCount, sampleCount int
+	for _, node := range stats.Nodes {
+		if node.VirtualNodes != node.Replicas {
+			t.Fatalf("node: %s, virtual nodes: %d, replicas: %d", node.NodeID, node.VirtualNodes, node.Replicas)
+		}
+		ownership += node.Ownership
+		keyCount += node.KeyCount
+		sampleCount += node.SampleCount
+	}
+	if math.Abs(ownership-1) > 1e-9 {
+		t.Fatalf("expect ownership sums to 1, got: %v", ownership)
+	}
+	if keyCount != 100 || sampleCount != 1000 {
+		t.Fatalf("expect 100 keys and 1000 samples, got: %d, %d", keyCount, sampleCount)
+	}
+	if stats.Keys == nil || stats.Samples == nil || stats.Ownership.PeakToAverage < 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 不记录数据 key，也没有样本
+	consistentHash = NewConsistentHash(local.NewSkiplistHashRing(), NewMurmurHasher(), nil, WithDataKeyTracking(DataKeyTrackingNone))
+	if _, err = consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = consistentHash.Stats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != nil || stats.Samples != nil || stats.Ownership.MaxMinRatio != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func Test_distribution_stats(t *testing.T) {
+	nodes := []*NodeStats{{KeyCount: 2}, {KeyCount: 4}, {KeyCount: 4}, {KeyCount: 4}, {KeyCount: 5}, {KeyCount: 5}, {KeyCount: 7}, {KeyCount: 9}}
+	stats := newDistributionStats(nodes, func(node *NodeStats) float64 {
+		return float64(node.KeyCount)
+	})
+	expect := DistributionStats{Mean: 5, StdDev: 2, Min: 2, Max: 9, MaxMinRatio: 4.5, PeakToAverage: 1.8}
+	if stats != expect {
+		t.Fatalf("expect: %+v, got: %+v", expect, stats)
+	}
+
+	nodes = []*NodeStats{{KeyCount: 0}, {KeyCount: 3}}
+	if stats = newDistributionStats(nodes, func(node *NodeStats) float64 {
+		return float64(node.KeyCount)
+	}); !math.IsInf(stats.MaxMinRatio, 1) {
+		t.Fatalf("expect +Inf max/min ratio, got: %v", stats.MaxMinRatio)
+	}
+}
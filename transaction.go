@@ -0,0 +1,365 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+)
+
+// 对哈希环的一次变更. 记录每一步写操作对应的回滚操作，变更中途失败时按相反的顺序回滚，
+// 保证节点变更要么全部生效，要么哈希环恢复到变更前的状态
+type ringTx struct {
+	hashRing     HashRing
+	dataKeyIndex DataKeyIndex
+	// 数据 key 索引按照 score 排序时，用于计算数据 key 的 score
+	hash  func(origin string) int64
+	undos []func(ctx context.Context) error
+}
+
+func newRingTx(hashRing HashRing, dataKeyIndex DataKeyIndex, hash func(origin string) int64) *ringTx {
+	return &ringTx{
+		hashRing:     hashRing,
+		dataKeyIndex: dataKeyIndex,
+		hash:         hash,
+	}
+}
+
+func (t *ringTx) Add(ctx context.Context, virtualNode ringVirtualNode) error {
+	if err := t.hashRing.Add(ctx, virtualNode.score, virtualNode.nodeID, virtualNode.index); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.hashRing.Rem(ctx, virtualNode.score, virtualNode.nodeID, virtualNode.index)
+	})
+	return nil
+}
+
+func (t *ringTx) Rem(ctx context.Context, virtualNode ringVirtualNode) error {
+	if err := t.hashRing.Rem(ctx, virtualNode.score, virtualNode.nodeID, virtualNode.index); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.hashRing.Add(ctx, virtualNode.score, virtualNode.nodeID, virtualNode.index)
+	})
+	return nil
+}
+
+func (t *ringTx) AddNodeToReplica(ctx context.Context, nodeID string, replicas int) error {
+	nodes, err := t.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	oldReplicas, existed := nodes[nodeID]
+
+	if err = t.hashRing.AddNodeToReplica(ctx, nodeID, replicas); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		if existed {
+			return t.hashRing.AddNodeToReplica(ctx, nodeID, oldReplicas)
+		}
+		return t.hashRing.DeleteNodeToReplica(ctx, nodeID)
+	})
+	return nil
+}
+
+func (t *ringTx) DeleteNodeToReplica(ctx context.Context, nodeID string) error {
+	nodes, err := t.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	oldReplicas, existed := nodes[nodeID]
+
+	if err = t.hashRing.DeleteNodeToReplica(ctx, nodeID); err != nil {
+		return err
+	}
+	if !existed {
+		return nil
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.hashRing.AddNodeToReplica(ctx, nodeID, oldReplicas)
+	})
+	return nil
+}
+
+func (t *ringTx) SetNodeMeta(ctx context.Context, nodeID, meta string) error {
+	metas, err := t.hashRing.NodeMetas(ctx)
+	if err != nil {
+		return err
+	}
+	oldMeta, existed := metas[nodeID]
+
+	if err = t.hashRing.SetNodeMeta(ctx, nodeID, meta); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		if existed {
+			return t.hashRing.SetNodeMeta(ctx, nodeID, oldMeta)
+		}
+		return t.hashRing.DeleteNodeMeta(ctx, nodeID)
+	})
+	return nil
+}
+
+func (t *ringTx) DeleteNodeMeta(ctx context.Context, nodeID string) error {
+	metas, err := t.hashRing.NodeMetas(ctx)
+	if err != nil {
+		return err
+	}
+	oldMeta, existed := metas[nodeID]
+	if !existed {
+		return nil
+	}
+
+	if err = t.hashRing.DeleteNodeMeta(ctx, nodeID); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.hashRing.SetNodeMeta(ctx, nodeID, oldMeta)
+	})
+	return nil
+}
+
+func (t *ringTx) SetNodeState(ctx context.Context, nodeID, state string) error {
+	states, err := t.hashRing.NodeStates(ctx)
+	if err != nil {
+		return err
+	}
+	oldState, existed := states[nodeID]
+
+	if err = t.hashRing.SetNodeState(ctx, nodeID, state); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		if existed {
+			return t.hashRing.SetNodeState(ctx, nodeID, oldState)
+		}
+		return t.hashRing.DeleteNodeState(ctx, nodeID)
+	})
+	return nil
+}
+
+func (t *ringTx) DeleteNodeState(ctx context.Context, nodeID string) error {
+	states, err := t.hashRing.NodeStates(ctx)
+	if err != nil {
+		return err
+	}
+	oldState, existed := states[nodeID]
+	if !existed {
+		return nil
+	}
+
+	if err = t.hashRing.DeleteNodeState(ctx, nodeID); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.hashRing.SetNodeState(ctx, nodeID, oldState)
+	})
+	return nil
+}
+
+func (t *ringTx) SetRingConfig(ctx context.Context, config string) error {
+	oldConfig, err := t.hashRing.RingConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = t.hashRing.SetRingConfig(ctx, config); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.hashRing.SetRingConfig(ctx, oldConfig)
+	})
+	return nil
+}
+
+// 调用方需要保证节点下原本不存在这些数据 key，否则回滚时会误删
+func (t *ringTx) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	if err := addDataKeys(ctx, t.dataKeyIndex, t.hash, nodeID, dataKeys); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.dataKeyIndex.DeleteNodeToDataKeys(ctx, nodeID, dataKeys)
+	})
+	return nil
+}
+
+// 将数据 key 的映射关系从 from 节点移动到 to 节点，过期时间随数据 key 一同移动. to 为空时只删除 from 下的映射关系.
+// 调用方需要保证 to 节点下原本不存在这些数据 key，否则回滚时会误删
+func (t *ringTx) MoveDataKeys(ctx context.Context, from, to string, dataKeys map[string]struct{}) error {
+	expireAts, err := t.dataKeyIndex.DataKeysExpireAt(ctx, from, dataKeys)
+	if err != nil {
+		return err
+	}
+
+	if err = t.dataKeyIndex.DeleteNodeToDataKeys(ctx, from, dataKeys); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		if err := addDataKeys(ctx, t.dataKeyIndex, t.hash, from, dataKeys); err != nil {
+			return err
+		}
+		return expireDataKeys(ctx, t.dataKeyIndex, from, expireAts)
+	})
+
+	if to == "" {
+		return nil
+	}
+
+	if err = addDataKeys(ctx, t.dataKeyIndex, t.hash, to, dataKeys); err != nil {
+		return err
+	}
+	t.undos = append(t.undos, func(ctx context.Context) error {
+		return t.dataKeyIndex.DeleteNodeToDataKeys(ctx, to, dataKeys)
+	})
+	return expireDataKeys(ctx, t.dataKeyIndex, to, expireAts)
+}
+
+// 数据 key 索引按照 score 排序时，写入数据 key 的同时写入 score
+func addDataKeys(ctx context.Context, dataKeyIndex DataKeyIndex, hash func(origin string) int64, nodeID string, dataKeys map[string]struct{}) error {
+	scoredIndex, ok := dataKeyIndex.(ScoredDataKeyIndex)
+	if !ok {
+		return dataKeyIndex.AddNodeToDataKeys(ctx, nodeID, dataKeys)
+	}
+
+	scores := make(map[string]int64, len(dataKeys))
+	for dataKey := range dataKeys {
+		scores[dataKey] = hash(dataKey)
+	}
+	return scoredIndex.AddNodeToScoredDataKeys(ctx, nodeID, scores)
+}
+
+// 按照过期时间分组，为节点下的数据 key 设置过期时间
+func expireDataKeys(ctx context.Context, dataKeyIndex DataKeyIndex, nodeID string, expireAts map[string]int64) error {
+	groups := make(map[int64]map[string]struct{})
+	for dataKey, expireAt := range expireAts {
+		if groups[expireAt] == nil {
+			groups[expireAt] = make(map[string]struct{})
+		}
+		groups[expireAt][dataKey] = struct{}{}
+	}
+
+	for expireAt, dataKeys := range groups {
+		if err := dataKeyIndex.ExpireDataKeys(ctx, nodeID, dataKeys, expireAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按照与写操作相反的顺序执行回滚. 单步回滚失败不会中断后续的回滚，所有失败都会体现在返回的错误中
+func (t *ringTx) rollback(ctx context.Context) error {
+	var errs []error
+	for i := len(t.undos) - 1; i >= 0; i-- {
+		if err := t.undos[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.undos = nil
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("rollback failed, %d errs: %v", len(errs), errs)
+}
+
+// 在持有哈希环锁的前提下执行一次节点变更：mutate 基于变更前的快照修改哈希环的拓扑，
+// 随后发布新的快照，并对比变更前后的哈希环调整数据 key 的映射关系.
+// 任何一步失败都会回滚全部写操作，并重新发布回滚后的快照. 成功时返回变更后哈希环的版本号
+func (c *ConsistentHash) commit(ctx context.Context, mutate func(tx *ringTx, before *ringSnapshot) error) (int64, []*MigrationTask, error) {
+	if c.optsErr != nil {
+		return 0, nil, c.optsErr
+	}
+
+	// 记录变更前的哈希环，用于和变更后的哈希环对比，推算出需要迁移的数据
+	before, err := c.currentSnapshot(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx := newRingTx(c.hashRing, c.dataKeyIndex, c.hash)
+	version, migrateTasks, err := c.commitTx(ctx, tx, before, mutate)
+	if err != nil {
+		return 0, nil, c.abort(ctx, tx, err)
+	}
+	return version, migrateTasks, nil
+}
+
+// 只变更节点的元数据等信息，不改变数据的分布，因此无需对比变更前后的哈希环迁移数据.
+// 依然会发布新的快照并递增版本号，使其他进程感知到变更
+func (c *ConsistentHash) commitMeta(ctx context.Context, mutate func(tx *ringTx) error) (int64, error) {
+	if c.optsErr != nil {
+		return 0, c.optsErr
+	}
+
+	tx := newRingTx(c.hashRing, c.dataKeyIndex, c.hash)
+	if err := c.markWriting(ctx); err != nil {
+		return 0, err
+	}
+	if err := c.recordRingConfig(ctx, tx); err != nil {
+		return 0, c.abort(ctx, tx, err)
+	}
+	if err := mutate(tx); err != nil {
+		return 0, c.abort(ctx, tx, err)
+	}
+
+	after, err := c.publishSnapshot(ctx)
+	if err != nil {
+		return 0, c.abort(ctx, tx, err)
+	}
+	return after.version, nil
+}
+
+// 变更失败，回滚全部写操作，并重新发布回滚后的快照. 任何一步失败都不会跳过后续步骤，错误一并返回
+func (c *ConsistentHash) abort(ctx context.Context, tx *ringTx, err error) error {
+	// 新快照可能已经发布，回滚前需要重新标记写入中
+	if markErr := c.markWriting(ctx); markErr != nil {
+		err = fmt.Errorf("%w, mark writing failed: %v", err, markErr)
+	}
+	if rollbackErr := tx.rollback(ctx); rollbackErr != nil {
+		err = fmt.Errorf("%w, %v", err, rollbackErr)
+	}
+	if _, publishErr := c.publishSnapshot(ctx); publishErr != nil {
+		err = fmt.Errorf("%w, publish snapshot failed: %v", err, publishErr)
+	}
+	return err
+}
+
+func (c *ConsistentHash) commitTx(ctx context.Context, tx *ringTx, before *ringSnapshot, mutate func(tx *ringTx, before *ringSnapshot) error) (int64, []*MigrationTask, error) {
+	if err := c.markWriting(ctx); err != nil {
+		return 0, nil, err
+	}
+	if err := c.recordRingConfig(ctx, tx); err != nil {
+		return 0, nil, err
+	}
+	if err := mutate(tx, before); err != nil {
+		return 0, nil, err
+	}
+
+	// 发布变更后的快照，此后的读请求都会路由到新的哈希环上
+	after, err := c.publishSnapshot(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// 对比变更前后的哈希环，推算出有哪些数据需要从哪个节点迁移到哪个节点
+	migrateTasks, err := c.migrate(ctx, tx, before, after)
+	if err != nil {
+		return 0, nil, err
+	}
+	return after.version, migrateTasks, nil
+}
+
+// 版本号为奇数表示哈希环正在被修改. 写入前将版本号推进到奇数，publishSnapshot 再推进到偶数，
+// 读请求据此判断读到的虚拟节点表是否完整，而无需获取哈希环的锁.
+// 倘若上一个写者中途退出导致版本号停留在奇数，则保持不变，由本次写入发布后恢复
+func (c *ConsistentHash) markWriting(ctx context.Context) error {
+	version, err := c.hashRing.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version%2 != 0 {
+		return nil
+	}
+	_, err = c.hashRing.IncrVersion(ctx)
+	return err
+}
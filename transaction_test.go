@@ -0,0 +1,180 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+// 在第 failAt 次写操作时返回错误的哈希环
+type faultyHashRing struct {
+	*local.SkiplistHashRing
+	writes, failAt int
+	// 第 failIncrAt 次递增版本号时返回错误
+	incrs, failIncrAt int
+}
+
+func (f *faultyHashRing) IncrVersion(ctx context.Context) (int64, error) {
+	f.incrs++
+	if f.incrs == f.failIncrAt {
+		return 0, errors.New("injected incr version failure")
+	}
+	return f.SkiplistHashRing.IncrVersion(ctx)
+}
+
+func (f *faultyHashRing) fail() error {
+	f.writes++
+	if f.writes == f.failAt {
+		return errors.New("injected failure")
+	}
+	return nil
+}
+
+func (f *faultyHashRing) Add(ctx context.Context, virtualScore int64, nodeID string, index int) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.SkiplistHashRing.Add(ctx, virtualScore, nodeID, index)
+}
+
+func (f *faultyHashRing) Rem(ctx context.Context, virtualScore int64, nodeID string, index int) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.SkiplistHashRing.Rem(ctx, virtualScore, nodeID, index)
+}
+
+func (f *faultyHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.SkiplistHashRing.AddNodeToDataKeys(ctx, nodeID, dataKeys)
+}
+
+func (f *faultyHashRing) AddNodeToScoredDataKeys(ctx context.Context, nodeID string, dataKeys map[string]int64) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.SkiplistHashRing.AddNodeToScoredDataKeys(ctx, nodeID, dataKeys)
+}
+
+type ringState struct {
+	Nodes        map[string]int
+	VirtualNodes map[int64]map[string][]int
+	DataKeys     map[string]map[string]struct{}
+}
+
+func dumpRingState(t *testing.T, hashRing interface {
+	HashRing
+	DataKeyIndex
+}) ringState {
+	ctx := context.Background()
+	nodes, _ := hashRing.Nodes(ctx)
+	virtualNodes, _ := hashRing.VirtualNodes(ctx)
+	state := ringState{
+		Nodes:        make(map[string]int),
+		VirtualNodes: virtualNodes,
+		DataKeys:     make(map[string]map[string]struct{}),
+	}
+	for nodeID, replicas := range nodes {
+		state.Nodes[nodeID] = replicas
+		dataKeys, _ := hashRing.DataKeys(ctx, nodeID)
+		state.DataKeys[nodeID] = dataKeys
+	}
+	return state
+}
+
+func Test_add_node_rollback(t *testing.T) {
+	ctx := context.Background()
+	for _, failAt := range []int{3, 10, 12} {
+		hashRing := &faultyHashRing{SkiplistHashRing: local.NewSkiplistHashRing()}
+		consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+			return nil
+		}, WithReplicas(5))
+
+		if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 50; i++ {
+			if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		before := dumpRingState(t, hashRing)
+		// 从当前写操作开始计数，第 failAt 次写操作失败：前 10 次写入虚拟节点，之后移动数据 key
+		hashRing.writes, hashRing.failAt = 0, failAt
+		if _, err := consistentHash.AddNode(ctx, "node_c", 2); err == nil {
+			t.Fatalf("fail at: %d, expect error", failAt)
+		}
+		hashRing.failAt = 0
+
+		if after := dumpRingState(t, hashRing); !reflect.DeepEqual(before, after) {
+			t.Fatalf("fail at: %d, ring not restored, before: %+v, after: %+v", failAt, before, after)
+		}
+
+		// 回滚后的快照同样需要恢复
+		node, err := consistentHash.GetNode(ctx, "data_0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node == "node_c" {
+			t.Fatalf("fail at: %d, data routed to rolled back node", failAt)
+		}
+	}
+}
+
+func Test_abort_version_failure(t *testing.T) {
+	ctx := context.Background()
+	// 前 5 次写操作写入虚拟节点，第 6 次移动数据 key 时失败. 第 1 次递增版本号标记写入中，第 2 次发布新快照，
+	// 第 3 次在回滚前重新标记写入中，第 4 次为回滚后发布快照
+	for _, failIncrAt := range []int{3, 4} {
+		hashRing := &faultyHashRing{SkiplistHashRing: local.NewSkiplistHashRing()}
+		consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+			return nil
+		}, WithReplicas(5))
+
+		if _, err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 50; i++ {
+			if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		before := dumpRingState(t, hashRing)
+		hashRing.writes, hashRing.failAt = 0, 6
+		hashRing.incrs, hashRing.failIncrAt = 0, failIncrAt
+		_, err := consistentHash.AddNode(ctx, "node_b", 1)
+		if err == nil || !strings.Contains(err.Error(), "injected incr version failure") {
+			t.Fatalf("fail incr at: %d, expect version error, got: %v", failIncrAt, err)
+		}
+		hashRing.failAt, hashRing.failIncrAt = 0, 0
+
+		// 版本号相关的错误不影响回滚
+		if after := dumpRingState(t, hashRing); !reflect.DeepEqual(before, after) {
+			t.Fatalf("fail incr at: %d, ring not restored, before: %+v, after: %+v", failIncrAt, before, after)
+		}
+
+		// 下一次变更会恢复版本号，之后的读请求能够重建快照
+		if _, err = consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+			t.Fatal(err)
+		}
+		if version, _ := hashRing.Version(ctx); isWriting(version) {
+			t.Fatalf("fail incr at: %d, version: %d still marked as writing", failIncrAt, version)
+		}
+		reader := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+		if _, err = reader.LocateN(ctx, "data_0", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package consistent_hash
+
+import "fmt"
+
+// 虚拟节点的命名方式. 虚拟节点的 score 由 Encryptor 对 VnodeKey 的结果计算得到，
+// 哈希环中以 (nodeID, index) 的形式存储虚拟节点，不会反向解析 VnodeKey，因此节点 id 可以包含任意字符.
+// 名称会记录在哈希环的配置中，不同的命名方式会得到不同的虚拟节点分布
+type VnodeKeyer interface {
+	Name() string
+	VnodeKey(nodeID string, index int) string
+}
+
+// 默认的命名方式 "nodeID_index"
+type DefaultVnodeKeyer struct {
+}
+
+func (d DefaultVnodeKeyer) Name() string {
+	return "default"
+}
+
+func (d DefaultVnodeKeyer) VnodeKey(nodeID string, index int) string {
+	return fmt.Sprintf("%s_%d", nodeID, index)
+}
+
+// 与 ketama 客户端一致的命名方式 "nodeID-index"
+type KetamaVnodeKeyer struct {
+}
+
+func (k KetamaVnodeKeyer) Name() string {
+	return "ketama"
+}
+
+func (k KetamaVnodeKeyer) VnodeKey(nodeID string, index int) string {
+	return fmt.Sprintf("%s-%d", nodeID, index)
+}
+
+// 在虚拟节点 key 中加入盐值，不同盐值的哈希环拥有互不相关的虚拟节点分布
+type SaltedVnodeKeyer struct {
+	Salt string
+}
+
+func (s SaltedVnodeKeyer) Name() string {
+	return fmt.Sprintf("salted_%s", s.Salt)
+}
+
+func (s SaltedVnodeKeyer) VnodeKey(nodeID string, index int) string {
+	return fmt.Sprintf("%s#%s#%d", s.Salt, nodeID, index)
+}
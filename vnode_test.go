@@ -0,0 +1,69 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_vnode_keyer(t *testing.T) {
+	ctx := context.Background()
+	hashRing := local.NewSkiplistHashRing()
+	keyer := SaltedVnodeKeyer{Salt: "blue"}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithVnodeKeyer(keyer), WithReplicas(4))
+
+	// 节点 id 中可以包含任意字符
+	nodeIDs := []string{"node_1", "10.0.0.1:6379#a", "节点-2"}
+	for _, nodeID := range nodeIDs {
+		if _, err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	virtualNodes, err := hashRing.VirtualNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeID := range nodeIDs {
+		for i := 0; i < 4; i++ {
+			score := consistentHash.hash(keyer.VnodeKey(nodeID, i))
+			if !containsIndex(virtualNodes[score][nodeID], i) {
+				t.Fatalf("virtual node: %s, index: %d not found at score: %d", nodeID, i, score)
+			}
+		}
+	}
+
+	snapshot, err := consistentHash.loadSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeID := range nodeIDs {
+		if snapshot.weights[nodeID] != 4 {
+			t.Fatalf("node: %s, unexpected weight: %d", nodeID, snapshot.weights[nodeID])
+		}
+	}
+
+	if _, err = consistentHash.RemoveNode(ctx, "10.0.0.1:6379#a"); err != nil {
+		t.Fatal(err)
+	}
+	if virtualNodes, _ = hashRing.VirtualNodes(ctx); len(virtualNodes) != 8 {
+		t.Fatalf("expect 8 virtual nodes, got: %d", len(virtualNodes))
+	}
+
+	// 更换虚拟节点的命名方式会改变虚拟节点的分布，需要被拒绝
+	switched := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(4))
+	if _, err = switched.AddNode(ctx, "node_3", 1); !errors.Is(err, ErrRingConfigMismatch) {
+		t.Fatalf("expect ring config mismatch, got: %v", err)
+	}
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, _index := range indexes {
+		if _index == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package consistent_hash
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// 哈希环变更事件的类型
+type RingEventType string
+
+const (
+	RingEventNodeAdded         RingEventType = "node_added"
+	RingEventNodeRemoved       RingEventType = "node_removed"
+	RingEventNodeWeightChanged RingEventType = "node_weight_changed"
+	RingEventNodeStateChanged  RingEventType = "node_state_changed"
+	RingEventNodeMetaChanged   RingEventType = "node_meta_changed"
+)
+
+// 哈希环的变更事件
+type RingEvent struct {
+	Type   RingEventType `json:"type"`
+	NodeID string        `json:"node_id"`
+	// 变更提交后哈希环的版本号，单调递增，每次提交前进 RingEventVersionStep
+	Version int64 `json:"version"`
+	// 变更后节点对应的虚拟节点个数，节点被删除时为 0
+	Replicas int `json:"replicas,omitempty"`
+	// 变更后节点的状态
+	State NodeState `json:"state,omitempty"`
+}
+
+// 每次提交的变更使哈希环的版本号前进 2：写入前推进到奇数标记写入中，发布时再推进到偶数
+const RingEventVersionStep = 2
+
+// 订阅哈希环的变更事件，包括其他进程通过同一个 HashRing 提交的变更. ctx 结束后关闭返回的 channel.
+// 事件按照版本号升序投递，重复或者乱序到达的旧事件会被丢弃. 相邻事件的版本号相差 RingEventVersionStep，
+// 相差更多时可能有事件丢失，例如消费过慢，或者订阅建立之前发生了变更；被回滚的变更同样会推进版本号而不产生事件.
+// 此时可以通过 NodeInfos 重新获取哈希环的全貌
+func (c *ConsistentHash) Watch(ctx context.Context) (<-chan *RingEvent, error) {
+	rawEvents, err := c.hashRing.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan *RingEvent)
+	go func() {
+		defer close(events)
+		var version int64
+		for rawEvent := range rawEvents {
+			var event RingEvent
+			if err := json.Unmarshal([]byte(rawEvent), &event); err != nil {
+				continue
+			}
+			if event.Version <= version {
+				continue
+			}
+			version = event.Version
+
+			select {
+			case events <- &event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// 变更已经提交，事件的发布是尽力而为的，发布失败不影响变更的结果
+func (c *ConsistentHash) publishEvent(ctx context.Context, event *RingEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_ = c.hashRing.Publish(ctx, string(body))
+}
@@ -0,0 +1,88 @@
+package consistent_hash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/consistent_hash/local"
+)
+
+func Test_watch_ring_events(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 两个实例共享同一个哈希环，模拟两个进程
+	hashRing := local.NewSkiplistHashRing()
+	writer := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+	watcher := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+
+	events, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.UpdateNodeWeight(ctx, "node_b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.SetNodeState(ctx, "node_a", NodeStateDraining); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []RingEvent{
+		{Type: RingEventNodeAdded, NodeID: "node_a", Replicas: 5, State: NodeStateActive},
+		{Type: RingEventNodeAdded, NodeID: "node_b", Replicas: 5, State: NodeStateActive},
+		{Type: RingEventNodeWeightChanged, NodeID: "node_b", Replicas: 10},
+		{Type: RingEventNodeStateChanged, NodeID: "node_a", Replicas: 5, State: NodeStateDraining},
+		{Type: RingEventNodeRemoved, NodeID: "node_a"},
+	}
+
+	var version int64
+	for _, expect := range expects {
+		select {
+		case event := <-events:
+			// 相邻提交的事件，版本号相差固定的步长
+			if version > 0 && event.Version != version+RingEventVersionStep {
+				t.Fatalf("expect version: %d, got: %d", version+RingEventVersionStep, event.Version)
+			}
+			if event.Version <= version {
+				t.Fatalf("expect version > %d, got: %d", version, event.Version)
+			}
+			version = event.Version
+			expect.Version = event.Version
+			if *event != expect {
+				t.Fatalf("expect event: %+v, got: %+v", expect, *event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for event: %+v", expect)
+		}
+	}
+
+	// 收到事件时，对应的变更已经可以读到
+	nodeInfos, err := watcher.NodeInfos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodeInfos) != 1 || nodeInfos[0].NodeID != "node_b" || nodeInfos[0].Replicas != 10 {
+		t.Fatalf("unexpected node infos: %+v", nodeInfos)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("expect events closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for events closed")
+	}
+}